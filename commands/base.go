@@ -4,7 +4,6 @@ import (
 	"fmt"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/davecgh/go-spew/spew"
 	"github.com/hobeone/mtgbrew/db"
 	"github.com/hobeone/mtgbrew/mtgjson"
 	// import sqlite driver
@@ -22,6 +21,14 @@ func RegisterCommands(app *kingpin.Application) {
 	search.configure(app)
 	serve := &webServer{}
 	serve.configure(app)
+	validate := &validateDeck{}
+	validate.configure(app)
+	convert := &convertDeck{}
+	convert.configure(app)
+	decks := &savedDecks{}
+	decks.configure(app)
+	prices := &cardPrices{}
+	prices.configure(app)
 }
 
 type migrateSchema struct {
@@ -34,56 +41,77 @@ func (m *migrateSchema) configure(app *kingpin.Application) {
 }
 
 func (m *migrateSchema) Migrate(c *kingpin.ParseContext) error {
-	dbh := db.NewDBHandle(m.DBPath, true, logrus.StandardLogger())
+	dbh, err := db.NewDBHandle(m.DBPath, true, logrus.StandardLogger())
+	if err != nil {
+		return err
+	}
 	return dbh.Migrate(db.SchemaMigrations())
 }
 
 type loadCardsToDatastore struct {
 	MTGJsonFilePath string
 	DBPath          string
+	Prune           bool
+	ContinueOnError bool
 }
 
 func (l *loadCardsToDatastore) configure(app *kingpin.Application) {
-	loadCards := app.Command("load", "load cards from mtgjson.com to Google Datastore").Action(l.LoadData)
-	loadCards.Flag("file", "File containing MTGJson extended set information").Required().StringVar(&l.MTGJsonFilePath)
+	loadCards := app.Command("load", "load or refresh cards from a mtgjson.com data dump").Action(l.LoadData)
+	loadCards.Flag("file", "File containing MTGJson extended set information (.json, .json.gz, .json.bz2, .json.xz or .zip)").Required().StringVar(&l.MTGJsonFilePath)
 
 	loadCards.Flag("dbpath", "Path to database").Required().StringVar(&l.DBPath)
-
+	loadCards.Flag("prune", "Delete cards that are no longer in the data dump").BoolVar(&l.Prune)
+	loadCards.Flag("continue-on-error", "Skip cards that can't be saved and report them at the end").BoolVar(&l.ContinueOnError)
 }
 
 func (l *loadCardsToDatastore) LoadData(c *kingpin.ParseContext) error {
-	collection, err := mtgjson.LoadCollection(l.MTGJsonFilePath)
+	dbh, err := db.NewDBHandle(l.DBPath, true, logrus.StandardLogger())
 	if err != nil {
 		return err
 	}
-
-	dbh := db.NewDBHandle(l.DBPath, true, logrus.StandardLogger())
-	err = db.SaveCards(dbh, collection)
-
+	opts := db.SaveOptions{
+		Prune:           l.Prune,
+		ContinueOnError: l.ContinueOnError,
+	}
+	summaries := []*db.SetSummary{}
+	codes := []string{}
+	// Sets are saved as they are read so the whole dump never has to fit in
+	// memory.
+	err = mtgjson.WalkCollection(l.MTGJsonFilePath, func(set mtgjson.Set) error {
+		summary, err := db.SaveSet(dbh, set, opts)
+		if err != nil {
+			return err
+		}
+		codes = append(codes, set.Code)
+		summaries = append(summaries, summary)
+		return nil
+	})
 	if err != nil {
 		return fmt.Errorf("Error importing cards: %s", err)
 	}
-	return nil
-}
 
-type searchCards struct {
-	ProjectID string
-	DBPath    string
-}
-
-func (s *searchCards) configure(app *kingpin.Application) {
-	searchCards := app.Command("search", "search cards").Action(s.Search)
-
-	searchCards.Flag("dbpath", "Path to database").Required().StringVar(&s.DBPath)
-}
+	if l.Prune {
+		pruned, err := db.PruneSets(dbh, codes)
+		if err != nil {
+			return fmt.Errorf("Error removing old sets: %s", err)
+		}
+		summaries = append(summaries, pruned...)
+	}
 
-func (s *searchCards) Search(c *kingpin.ParseContext) error {
-	dbh := db.NewDBHandle(s.DBPath, true, logrus.StandardLogger())
-	//dbh := NewMemoryDBHandle(true, logrus.StandardLogger(), true)
-	cards, err := db.SearchCards(dbh, []string{"name"}, [][]string{[]string{"Selfless Spirit"}})
-	if err != nil {
-		return err
+	total := db.SetSummary{Code: "Total"}
+	for _, s := range summaries {
+		if s.Added+s.Updated+s.Removed+len(s.Skipped) > 0 {
+			fmt.Println(s)
+		}
+		total.Added += s.Added
+		total.Updated += s.Updated
+		total.Removed += s.Removed
+		total.Unchanged += s.Unchanged
+		total.Skipped = append(total.Skipped, s.Skipped...)
+	}
+	fmt.Println(total)
+	for _, skipped := range total.Skipped {
+		fmt.Printf("Skipped %s\n", skipped)
 	}
-	spew.Dump(cards)
 	return nil
 }
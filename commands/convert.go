@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/server"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+type convertDeck struct {
+	DBPath   string
+	From     string
+	To       string
+	Output   string
+	DeckFile string
+}
+
+func (d *convertDeck) configure(app *kingpin.Application) {
+	convert := app.Command("convert", "convert a deck list between deck file formats").Action(d.Convert)
+	convert.Flag("dbpath", "Path to database").Required().StringVar(&d.DBPath)
+	convert.Flag("from", "Format of the deck file").Default("auto").EnumVar(&d.From, "auto", "text", "arena", "mtgo", "cockatrice", "forge")
+	convert.Flag("to", "Format to write").Required().EnumVar(&d.To, "text", "arena", "mtgo", "cockatrice", "forge", "csv", "json")
+	convert.Flag("output", "File to write to instead of stdout").Short('o').StringVar(&d.Output)
+	convert.Arg("deck", "Deck list file").Required().ExistingFileVar(&d.DeckFile)
+}
+
+func (d *convertDeck) Convert(c *kingpin.ParseContext) error {
+	logrus.SetOutput(os.Stderr)
+	dbh, err := db.NewDBHandle(d.DBPath, false, logrus.StandardLogger())
+	if err != nil {
+		return err
+	}
+	writer, err := server.DeckWriterFor(d.To)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(d.DeckFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	from := d.From
+	if from == "auto" {
+		from = ""
+	}
+	deck, errs := server.ReadDeck(dbh, f, from)
+
+	var out io.Writer = os.Stdout
+	if d.Output != "" {
+		o, err := os.Create(d.Output)
+		if err != nil {
+			return err
+		}
+		defer o.Close()
+		out = o
+	}
+	err = writer.Write(out, deck)
+	if err != nil {
+		return err
+	}
+	// Cards that couldn't be read are left out of the converted deck
+	for _, e := range errs {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d lines of %s couldn't be converted", len(errs), d.DeckFile)
+	}
+	return nil
+}
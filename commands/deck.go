@@ -0,0 +1,204 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/server"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+type savedDecks struct {
+	DBPath   string
+	Name     string
+	Note     string
+	From     string
+	To       string
+	DeckFile string
+	Version  int
+	Versions []int
+}
+
+func (d *savedDecks) configure(app *kingpin.Application) {
+	deck := app.Command("deck", "save and compare versions of deck lists")
+	deck.Flag("dbpath", "Path to database").Required().StringVar(&d.DBPath)
+
+	save := deck.Command("save", "save a deck list as the next version of a deck").Action(d.Save)
+	save.Flag("note", "Note describing the changes in this version").StringVar(&d.Note)
+	save.Flag("from", "Format of the deck file").Default("auto").EnumVar(&d.From, "auto", "text", "arena", "mtgo", "cockatrice", "forge")
+	save.Arg("name", "Deck name").Required().StringVar(&d.Name)
+	save.Arg("deck", "Deck list file").Required().ExistingFileVar(&d.DeckFile)
+
+	list := deck.Command("list", "list saved decks, or the versions of one deck").Action(d.List)
+	list.Arg("name", "Deck name").StringVar(&d.Name)
+
+	diff := deck.Command("diff", "show the cards added and removed between two versions of a deck, by default the last two").Action(d.Diff)
+	diff.Arg("name", "Deck name").Required().StringVar(&d.Name)
+	diff.Arg("versions", "Versions to compare").IntsVar(&d.Versions)
+
+	show := deck.Command("show", "print a version of a deck, by default the latest").Action(d.Show)
+	show.Flag("version", "Version to show").IntVar(&d.Version)
+	show.Flag("to", "Format to write").Default("text").EnumVar(&d.To, "text", "arena", "mtgo", "cockatrice", "forge", "csv", "json")
+	show.Arg("name", "Deck name").Required().StringVar(&d.Name)
+
+	restore := deck.Command("restore", "save an old version of a deck as its latest version").Action(d.Restore)
+	restore.Arg("name", "Deck name").Required().StringVar(&d.Name)
+	restore.Arg("version", "Version to restore").Required().IntVar(&d.Version)
+}
+
+func (d *savedDecks) open() (*db.Handle, error) {
+	logrus.SetOutput(os.Stderr)
+	return db.NewDBHandle(d.DBPath, false, logrus.StandardLogger())
+}
+
+func (d *savedDecks) deck(dbh *db.Handle) (*db.Deck, error) {
+	deck, err := db.DeckByName(dbh, d.Name)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("No deck named '%s'", d.Name)
+	}
+	return deck, err
+}
+
+func (d *savedDecks) Save(c *kingpin.ParseContext) error {
+	dbh, err := d.open()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(d.DeckFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	from := d.From
+	if from == "auto" {
+		from = ""
+	}
+	cards, errs := server.ReadDeck(dbh, f, from)
+	for _, e := range errs {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d lines of %s couldn't be read, deck not saved", len(errs), d.DeckFile)
+	}
+	v, err := server.SaveDeck(dbh, d.Name, d.Note, cards)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved %s version %d\n", d.Name, v.Version)
+	return nil
+}
+
+func (d *savedDecks) List(c *kingpin.ParseContext) error {
+	dbh, err := d.open()
+	if err != nil {
+		return err
+	}
+	if d.Name == "" {
+		decks, err := db.Decks(dbh)
+		if err != nil {
+			return err
+		}
+		for _, deck := range decks {
+			fmt.Printf("%s\tversion %d\t%s\n", deck.Name, deck.Version, deck.Updated.Local().Format("2006-01-02 15:04"))
+		}
+		return nil
+	}
+	deck, err := d.deck(dbh)
+	if err != nil {
+		return err
+	}
+	versions, err := db.DeckVersions(dbh, deck.ID)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		fmt.Printf("%d\t%s\t%s\n", v.Version, v.Created.Local().Format("2006-01-02 15:04"), v.Note)
+	}
+	return nil
+}
+
+func (d *savedDecks) Diff(c *kingpin.ParseContext) error {
+	dbh, err := d.open()
+	if err != nil {
+		return err
+	}
+	deck, err := d.deck(dbh)
+	if err != nil {
+		return err
+	}
+	from, to := deck.Version-1, deck.Version
+	switch len(d.Versions) {
+	case 0:
+		if from < 1 {
+			from = 1
+		}
+	case 2:
+		from, to = d.Versions[0], d.Versions[1]
+	default:
+		return fmt.Errorf("Give two versions to compare or none to compare the last two")
+	}
+	_, fromCards, err := server.LoadDeckVersion(dbh, deck.ID, from)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s has no version %d", d.Name, from)
+	}
+	if err != nil {
+		return err
+	}
+	_, toCards, err := server.LoadDeckVersion(dbh, deck.ID, to)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s has no version %d", d.Name, to)
+	}
+	if err != nil {
+		return err
+	}
+	diff := server.DiffDecks(fromCards, toCards)
+	diff.From, diff.To = from, to
+	fmt.Println(diff)
+	return nil
+}
+
+func (d *savedDecks) Show(c *kingpin.ParseContext) error {
+	dbh, err := d.open()
+	if err != nil {
+		return err
+	}
+	writer, err := server.DeckWriterFor(d.To)
+	if err != nil {
+		return err
+	}
+	deck, err := d.deck(dbh)
+	if err != nil {
+		return err
+	}
+	_, cards, err := server.LoadDeckVersion(dbh, deck.ID, d.Version)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s has no version %d", d.Name, d.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return writer.Write(os.Stdout, cards)
+}
+
+func (d *savedDecks) Restore(c *kingpin.ParseContext) error {
+	dbh, err := d.open()
+	if err != nil {
+		return err
+	}
+	deck, err := d.deck(dbh)
+	if err != nil {
+		return err
+	}
+	v, err := db.RestoreDeckVersion(dbh, deck.ID, d.Version)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s has no version %d", d.Name, d.Version)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s version %d as version %d\n", d.Name, d.Version, v.Version)
+	return nil
+}
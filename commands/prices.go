@@ -0,0 +1,192 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+	"github.com/hobeone/mtgbrew/server"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// priceBatchSize is how many cards' prices are saved per transaction
+const priceBatchSize = 1000
+
+type cardPrices struct {
+	DBPath     string
+	PriceFile  string
+	Vendor     string
+	Finish     string
+	Days       int
+	Collection int64
+	Limit      int
+	MinPrice   float64
+}
+
+func (p *cardPrices) configure(app *kingpin.Application) {
+	prices := app.Command("prices", "import and report on card prices")
+	prices.Flag("dbpath", "Path to database").Required().StringVar(&p.DBPath)
+
+	load := prices.Command("import", "import prices from a mtgjson.com AllPrices or AllPricesToday file or a vendor CSV").Action(p.Import)
+	load.Flag("vendor", "Vendor for CSV rows without a vendor column, e.g. scg").StringVar(&p.Vendor)
+	load.Arg("file", "Price file (.json, compressed .json or .csv)").Required().ExistingFileVar(&p.PriceFile)
+
+	movers := prices.Command("movers", "list the cards whose prices changed most").Action(p.Movers)
+	movers.Flag("days", "Compare the latest prices to prices this many days earlier").Default("7").IntVar(&p.Days)
+	movers.Flag("vendor", "Vendor to compare prices from").Default("tcgplayer").StringVar(&p.Vendor)
+	movers.Flag("finish", "Finish to compare prices of").Default(db.FinishNormal).StringVar(&p.Finish)
+	movers.Flag("collection", "Only list cards in the collection with this id").Int64Var(&p.Collection)
+	movers.Flag("limit", "Number of cards to list for each report").Default("10").IntVar(&p.Limit)
+	movers.Flag("min-price", "Ignore cards that cost less than this before and after").Default("1").Float64Var(&p.MinPrice)
+}
+
+func (p *cardPrices) open() (*db.Handle, error) {
+	logrus.SetOutput(os.Stderr)
+	return db.NewDBHandle(p.DBPath, false, logrus.StandardLogger())
+}
+
+func (p *cardPrices) Import(c *kingpin.ParseContext) error {
+	dbh, err := p.open()
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(filepath.Ext(p.PriceFile)) == ".csv" {
+		return p.importCSV(dbh)
+	}
+
+	count := 0
+	batch := []mtgjson.Price{}
+	cards := 0
+	unknown := 0
+	save := func() error {
+		n, err := db.UnknownPriceCards(dbh, batch)
+		if err != nil {
+			return err
+		}
+		unknown += n
+		count += len(batch)
+		err = db.SavePrices(dbh, batch)
+		batch = batch[:0]
+		return err
+	}
+	err = mtgjson.WalkPrices(p.PriceFile, func(prices []mtgjson.Price) error {
+		batch = append(batch, prices...)
+		cards++
+		if cards%priceBatchSize != 0 {
+			return nil
+		}
+		return save()
+	})
+	if err == nil {
+		err = save()
+	}
+	if err != nil {
+		return fmt.Errorf("Error importing prices: %s", err)
+	}
+	fmt.Printf("Imported %d prices for %d cards\n", count, cards)
+	return checkUnknownPriceCards(unknown, cards)
+}
+
+// checkUnknownPriceCards warns about MTGJSON prices for cards that aren't
+// loaded and fails if none of them are.
+func checkUnknownPriceCards(unknown, cards int) error {
+	if unknown == 0 {
+		return nil
+	}
+	if unknown == cards {
+		return fmt.Errorf("None of the %d cards with prices are loaded.  MTGJSON prices are keyed by v5 uuids, load cards from a v5 AllPrintings file", cards)
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d cards with prices aren't loaded, their prices won't be used\n", unknown, cards)
+	return nil
+}
+
+func (p *cardPrices) importCSV(dbh *db.Handle) error {
+	f, err := os.Open(p.PriceFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	prices, errs := server.ReadPriceCSV(dbh, f, strings.ToLower(p.Vendor))
+	err = db.SavePrices(dbh, prices)
+	if err != nil {
+		return fmt.Errorf("Error importing prices: %s", err)
+	}
+	fmt.Printf("Imported %d prices\n", len(prices))
+	// Rows that couldn't be read are skipped
+	for _, e := range errs {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d lines of %s couldn't be imported", len(errs), p.PriceFile)
+	}
+	return nil
+}
+
+func (p *cardPrices) Movers(c *kingpin.ParseContext) error {
+	if p.Days < 1 {
+		return fmt.Errorf("--days must be at least 1")
+	}
+	dbh, err := p.open()
+	if err != nil {
+		return err
+	}
+	if p.Collection != 0 {
+		if _, err := db.CollectionByID(dbh, p.Collection); err != nil {
+			return fmt.Errorf("Error getting collection %d: %s", p.Collection, err)
+		}
+	}
+	all, err := db.PriceMovers(dbh, strings.ToLower(p.Vendor), strings.ToLower(p.Finish), p.Days, p.Collection)
+	if err != nil {
+		return fmt.Errorf("Error comparing prices: %s", err)
+	}
+	moves := []db.PriceMove{}
+	for _, m := range all {
+		// Cheap cards swing by large percentages on small changes
+		if m.OldPrice >= p.MinPrice || m.Price.Price >= p.MinPrice {
+			moves = append(moves, m)
+		}
+	}
+	if len(moves) == 0 {
+		fmt.Printf("No %s %s prices from %d days before the latest\n", p.Vendor, p.Finish, p.Days)
+		return nil
+	}
+
+	percent := func(m db.PriceMove) float64 { return m.Percent() }
+	change := func(m db.PriceMove) float64 { return m.Change() }
+	printMovers("Gainers by percent", moves, percent, 1, p.Limit)
+	printMovers("Losers by percent", moves, percent, -1, p.Limit)
+	printMovers("Gainers by price", moves, change, 1, p.Limit)
+	printMovers("Losers by price", moves, change, -1, p.Limit)
+	return nil
+}
+
+// printMovers prints up to limit moves with the largest by value in the
+// direction of sign.
+func printMovers(title string, moves []db.PriceMove, by func(db.PriceMove) float64, sign float64, limit int) {
+	sorted := make([]db.PriceMove, 0, len(moves))
+	for _, m := range moves {
+		if by(m)*sign > 0 {
+			sorted = append(sorted, m)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return by(sorted[i])*sign > by(sorted[j])*sign
+	})
+	if len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	fmt.Printf("%s:\n", title)
+	if len(sorted) == 0 {
+		fmt.Println("  none")
+	}
+	for _, m := range sorted {
+		fmt.Printf("  %s\t%s %s\t%.2f -> %.2f %s\t%+.2f\t%+.1f%%\n",
+			m.Name, m.SetCode, m.Number, m.OldPrice, m.Price.Price, m.Currency, m.Change(), m.Percent())
+	}
+	fmt.Println()
+}
@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+type searchCards struct {
+	DBPath    string
+	Query     []string
+	Format    string
+	Sort      string
+	Desc      bool
+	Limit     int
+	Printings bool
+}
+
+func (s *searchCards) configure(app *kingpin.Application) {
+	searchCards := app.Command("search", "search cards by name or query, e.g. 'c:rg t:creature cmc<=3'").Action(s.Search)
+
+	searchCards.Flag("dbpath", "Path to database").Required().StringVar(&s.DBPath)
+	searchCards.Flag("format", "Output format").Short('f').Default("table").EnumVar(&s.Format, "table", "json", "csv", "names")
+	searchCards.Flag("sort", "Sort order").Default("name").EnumVar(&s.Sort, "name", "cmc", "date", "rarity", "power")
+	searchCards.Flag("desc", "Sort in descending order").BoolVar(&s.Desc)
+	searchCards.Flag("limit", "Maximum number of cards to show (0 for no limit)").Short('n').Default("0").IntVar(&s.Limit)
+	searchCards.Flag("all-printings", "Show every printing instead of one card per name").BoolVar(&s.Printings)
+	searchCards.Arg("query", "Card name or search query").Required().StringsVar(&s.Query)
+}
+
+func (s *searchCards) Search(c *kingpin.ParseContext) error {
+	// Keep stdout clean for the results so they can be piped elsewhere.
+	logrus.SetOutput(os.Stderr)
+	dbh, err := db.NewDBHandle(s.DBPath, false, logrus.StandardLogger())
+	if err != nil {
+		return err
+	}
+	opts := db.SearchOptions{
+		Sort:   s.Sort,
+		Desc:   s.Desc,
+		Limit:  s.Limit,
+		Unique: !s.Printings,
+	}
+	query := strings.Join(s.Query, " ")
+	// Names with characters the query language doesn't understand are searched
+	// for as a quoted phrase instead.
+	q, err := db.ParseQuery(query)
+	if err != nil {
+		logrus.Debugf("Searching for '%s' as a name: %s", query, err)
+		q, err = db.ParseQuery(fmt.Sprintf("name:\"%s\"", strings.Replace(query, `"`, "", -1)))
+		if err != nil {
+			return err
+		}
+	}
+	cards, _, err := db.SearchCardsParsed(dbh, q, opts)
+	if err != nil {
+		return err
+	}
+	return writeCards(os.Stdout, s.Format, cards)
+}
+
+func writeCards(w io.Writer, format string, cards []mtgjson.Card) error {
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(cards, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"name", "set", "number", "mana_cost", "cmc", "type", "rarity", "power", "toughness"})
+		for _, card := range cards {
+			cw.Write([]string{
+				card.Name,
+				card.SetCode,
+				card.Number,
+				card.ManaCost,
+				fmt.Sprintf("%g", card.CMC),
+				card.Type,
+				card.Rarity,
+				card.Power,
+				card.Toughness,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "names":
+		for _, card := range cards {
+			fmt.Fprintln(w, card.Name)
+		}
+		return nil
+	default:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSET\tCOST\tTYPE\tRARITY\tP/T")
+		for _, card := range cards {
+			pt := ""
+			if card.Power != "" || card.Toughness != "" {
+				pt = card.Power + "/" + card.Toughness
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", card.Name, card.SetCode, card.ManaCost, card.Type, card.Rarity, pt)
+		}
+		return tw.Flush()
+	}
+}
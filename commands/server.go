@@ -19,7 +19,10 @@ func (s *webServer) configure(app *kingpin.Application) {
 }
 
 func (s *webServer) Serve(c *kingpin.ParseContext) error {
-	dbh := db.NewDBHandle(s.DBPath, true, logrus.StandardLogger())
+	dbh, err := db.NewDBHandle(s.DBPath, true, logrus.StandardLogger())
+	if err != nil {
+		return err
+	}
 
 	d := server.Dependencies{
 		DBH: dbh,
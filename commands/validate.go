@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/server"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+type validateDeck struct {
+	DBPath    string
+	Format    string
+	Commander string
+	DeckFile  string
+}
+
+func (v *validateDeck) configure(app *kingpin.Application) {
+	validate := app.Command("validate", "check a deck list against a format's deck building rules").Action(v.Validate)
+	validate.Flag("dbpath", "Path to database").Required().StringVar(&v.DBPath)
+	validate.Flag("format", "Format to check against, e.g. modern").Required().StringVar(&v.Format)
+	validate.Flag("commander", "Commander name, defaults to the cards in the sideboard").StringVar(&v.Commander)
+	validate.Arg("deck", "Deck list file").Required().ExistingFileVar(&v.DeckFile)
+}
+
+func (v *validateDeck) Validate(c *kingpin.ParseContext) error {
+	logrus.SetOutput(os.Stderr)
+	dbh, err := db.NewDBHandle(v.DBPath, false, logrus.StandardLogger())
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(v.DeckFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res, err := server.ValidateDeck(dbh, f, v.Format, v.Commander)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d main, %d sideboard\n", res.MainCount, res.SideboardCount)
+	for _, violation := range res.Violations {
+		if violation.Card == "" {
+			fmt.Println(violation.Message)
+		} else {
+			fmt.Printf("%s: %s\n", violation.Card, violation.Message)
+		}
+	}
+	if !res.Legal {
+		return fmt.Errorf("Deck is not legal in %s", res.Format)
+	}
+	fmt.Printf("Deck is legal in %s\n", res.Format)
+	return nil
+}
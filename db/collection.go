@@ -0,0 +1,241 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Conditions are the card conditions a collection can record, best first
+var Conditions = []string{"NM", "LP", "MP", "HP", "DMG"}
+
+const (
+	// DefaultCondition is used for cards added without a condition
+	DefaultCondition = "NM"
+	// DefaultLanguage is used for cards added without a language
+	DefaultLanguage = "English"
+)
+
+// ValidCondition returns true if cond is one of Conditions
+func ValidCondition(cond string) bool {
+	for _, c := range Conditions {
+		if cond == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Collection is a named set of cards someone owns
+type Collection struct {
+	ID      int64     `json:"id"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+// CollectionCard is how many copies of one printing of a card are in a
+// collection.  Name, SetCode and Number come from the card table.  The name
+// is also saved with the entry so cards whose printing is no longer loaded,
+// after a prune or a reload with different ids, are still listed by name.
+type CollectionCard struct {
+	ID           int64  `json:"id"`
+	CollectionID int64  `json:"collection_id" db:"collection_id"`
+	MTGJsonID    string `json:"mtg_json_id" db:"mtg_json_id"`
+	Name         string `json:"name"`
+	SetCode      string `json:"set" db:"set_code"`
+	Number       string `json:"number"`
+	Quantity     int    `json:"quantity"`
+	Foil         bool   `json:"foil"`
+	Condition    string `json:"condition"`
+	Language     string `json:"language"`
+}
+
+// normalize fills in defaults and checks the fields that can be edited
+func (c *CollectionCard) normalize() error {
+	if c.Quantity < 1 {
+		return fmt.Errorf("Quantity must be > 0")
+	}
+	if c.Condition == "" {
+		c.Condition = DefaultCondition
+	}
+	c.Condition = strings.ToUpper(c.Condition)
+	if !ValidCondition(c.Condition) {
+		return fmt.Errorf("Unknown condition '%s', expected one of %s", c.Condition, strings.Join(Conditions, ", "))
+	}
+	if c.Language == "" {
+		c.Language = DefaultLanguage
+	}
+	return nil
+}
+
+// CreateCollection adds a new, empty collection
+func CreateCollection(dbh *Handle, name string) (*Collection, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("Collection name can't be empty")
+	}
+	coll := &Collection{Name: name, Created: time.Now().UTC()}
+	res, err := dbh.db.Exec(`INSERT INTO collection (name, created) VALUES (?, ?)`, coll.Name, coll.Created)
+	if err != nil {
+		return nil, err
+	}
+	coll.ID, err = res.LastInsertId()
+	return coll, err
+}
+
+// Collections returns all collections ordered by name
+func Collections(dbh *Handle) ([]Collection, error) {
+	colls := []Collection{}
+	err := dbh.db.Select(&colls, "SELECT * FROM collection ORDER BY name, id")
+	return colls, err
+}
+
+// CollectionByID returns the collection with the given id
+func CollectionByID(dbh *Handle, id int64) (*Collection, error) {
+	coll := Collection{}
+	err := dbh.db.Get(&coll, "SELECT * FROM collection WHERE id = ?", id)
+	return &coll, err
+}
+
+// RenameCollection changes the name of a collection
+func RenameCollection(dbh *Handle, id int64, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("Collection name can't be empty")
+	}
+	res, err := dbh.db.Exec("UPDATE collection SET name = ? WHERE id = ?", name, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// DeleteCollection removes a collection and all of its cards
+func DeleteCollection(dbh *Handle, id int64) error {
+	tx, err := dbh.db.Beginx()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM collection_card WHERE collection_id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM collection WHERE id = ?", id)
+	if err == nil {
+		err = requireRow(res)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// requireRow returns sql.ErrNoRows if res didn't change anything
+func requireRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const collectionCardSelect = `SELECT cc.id, cc.collection_id, cc.mtg_json_id, cc.quantity, cc.foil, cc.condition, cc.language,
+  COALESCE(card.name, cc.name) AS name, COALESCE(card.set_code, '') AS set_code, COALESCE(card.number, '') AS number
+FROM collection_card cc LEFT JOIN card ON card.mtg_json_id = cc.mtg_json_id `
+
+// CollectionCards returns the cards in a collection ordered by name and set
+func CollectionCards(dbh *Handle, id int64) ([]CollectionCard, error) {
+	cards := []CollectionCard{}
+	err := dbh.db.Select(&cards, collectionCardSelect+
+		"WHERE cc.collection_id = ? ORDER BY COALESCE(card.search_name, lower(cc.name)), card.release_date, cc.foil, cc.condition, cc.language", id)
+	return cards, err
+}
+
+// CollectionCardByID returns one entry in a collection
+func CollectionCardByID(dbh *Handle, collectionID, id int64) (*CollectionCard, error) {
+	card := CollectionCard{}
+	err := dbh.db.Get(&card, collectionCardSelect+"WHERE cc.collection_id = ? AND cc.id = ?", collectionID, id)
+	return &card, err
+}
+
+// AddCollectionCards adds cards to a collection.  Cards matching an
+// existing entry's printing, finish, condition and language add to its
+// quantity.
+func AddCollectionCards(dbh *Handle, collectionID int64, cards []CollectionCard) error {
+	tx, err := dbh.db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, c := range cards {
+		err = c.normalize()
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %s", c.Name, err)
+		}
+		_, err = tx.Exec(`INSERT INTO collection_card (collection_id, mtg_json_id, name, quantity, foil, condition, language)
+VALUES (?, ?, COALESCE((SELECT name FROM card WHERE mtg_json_id = ?), ?), ?, ?, ?, ?)
+ON CONFLICT (collection_id, mtg_json_id, foil, condition, language) DO UPDATE SET quantity = quantity + excluded.quantity`,
+			collectionID, c.MTGJsonID, c.MTGJsonID, c.Name, c.Quantity, c.Foil, c.Condition, c.Language)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateCollectionCard sets the quantity, finish, condition and language of
+// an entry in a collection.  If another entry for the same printing already
+// has the new finish, condition and language the cards are added to it and
+// this entry is deleted.  The id of the entry holding the cards is returned.
+func UpdateCollectionCard(dbh *Handle, card CollectionCard) (int64, error) {
+	err := card.normalize()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := dbh.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	var other int64
+	err = tx.Get(&other, `SELECT other.id FROM collection_card cc JOIN collection_card other
+  ON other.collection_id = cc.collection_id AND other.mtg_json_id = cc.mtg_json_id AND other.id != cc.id
+WHERE cc.collection_id = ? AND cc.id = ? AND other.foil = ? AND other.condition = ? AND other.language = ?`,
+		card.CollectionID, card.ID, card.Foil, card.Condition, card.Language)
+	var res sql.Result
+	switch err {
+	case nil:
+		_, err = tx.Exec(`UPDATE collection_card SET quantity = quantity + ? WHERE id = ?`, card.Quantity, other)
+		if err == nil {
+			res, err = tx.Exec(`DELETE FROM collection_card WHERE collection_id = ? AND id = ?`, card.CollectionID, card.ID)
+		}
+	case sql.ErrNoRows:
+		other = card.ID
+		res, err = tx.Exec(`UPDATE collection_card SET quantity = ?, foil = ?, condition = ?, language = ?
+WHERE collection_id = ? AND id = ?`,
+			card.Quantity, card.Foil, card.Condition, card.Language, card.CollectionID, card.ID)
+	}
+	if err == nil {
+		err = requireRow(res)
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return other, tx.Commit()
+}
+
+// DeleteCollectionCard removes an entry from a collection
+func DeleteCollectionCard(dbh *Handle, collectionID, id int64) error {
+	res, err := dbh.db.Exec("DELETE FROM collection_card WHERE collection_id = ? AND id = ?", collectionID, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+func TestCollectionCards(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	sets := map[string]mtgjson.Set{
+		"LEA": {
+			Code:  "LEA",
+			Cards: []*mtgjson.Card{{MTGJsonID: "1", SetCode: "LEA", Name: "Shivan Dragon", Number: "174"}},
+		},
+		"M10": {
+			Code:  "M10",
+			Cards: []*mtgjson.Card{{MTGJsonID: "2", SetCode: "M10", Name: "Shivan Dragon", Number: "158"}},
+		},
+	}
+	err := SaveCards(dbh, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coll, err := CreateCollection(dbh, " Binder ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coll.Name != "Binder" {
+		t.Fatalf("Expected name to be trimmed got '%s'", coll.Name)
+	}
+
+	err = AddCollectionCards(dbh, coll.ID, []CollectionCard{
+		{MTGJsonID: "1", Quantity: 1},
+		{MTGJsonID: "2", Quantity: 2, Foil: true},
+		{MTGJsonID: "1", Quantity: 2, Condition: "nm", Language: "English"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cards, err := CollectionCards(dbh, coll.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 2 {
+		t.Fatalf("Expected matching printings to be merged got %+v", cards)
+	}
+	if cards[0].SetCode != "LEA" || cards[0].Quantity != 3 || cards[0].Condition != "NM" || cards[0].Language != "English" {
+		t.Fatalf("Expected 3 NM English LEA Shivan Dragons got %+v", cards[0])
+	}
+	if cards[1].SetCode != "M10" || !cards[1].Foil || cards[1].Name != "Shivan Dragon" {
+		t.Fatalf("Expected foil M10 Shivan Dragon got %+v", cards[1])
+	}
+
+	err = AddCollectionCards(dbh, coll.ID, []CollectionCard{{MTGJsonID: "1", Quantity: 1, Condition: "mint"}})
+	if err == nil {
+		t.Fatalf("Expected error for unknown condition")
+	}
+
+	card := cards[1]
+	card.Quantity = 4
+	card.Condition = "LP"
+	id, err := UpdateCollectionCard(dbh, card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != card.ID {
+		t.Fatalf("Expected the entry to keep its id %d got %d", card.ID, id)
+	}
+	updated, err := CollectionCardByID(dbh, coll.ID, card.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Quantity != 4 || updated.Condition != "LP" {
+		t.Fatalf("Expected update to be saved got %+v", updated)
+	}
+
+	// Updating an entry to match another merges them
+	err = AddCollectionCards(dbh, coll.ID, []CollectionCard{{MTGJsonID: "2", Quantity: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cards, err = CollectionCards(dbh, coll.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := cards[1]
+	if other.ID == card.ID || other.Foil {
+		t.Fatalf("Expected the non-foil M10 entry got %+v", other)
+	}
+	other.Foil = true
+	other.Condition = "LP"
+	id, err = UpdateCollectionCard(dbh, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != card.ID {
+		t.Fatalf("Expected the cards to be merged into %d got %d", card.ID, id)
+	}
+	merged, err := CollectionCardByID(dbh, coll.ID, card.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Quantity != 5 {
+		t.Fatalf("Expected the quantities to be added got %+v", merged)
+	}
+	_, err = CollectionCardByID(dbh, coll.ID, other.ID)
+	if err != sql.ErrNoRows {
+		t.Fatalf("Expected the merged entry to be deleted got %v", err)
+	}
+	_, err = UpdateCollectionCard(dbh, other)
+	if err != sql.ErrNoRows {
+		t.Fatalf("Expected sql.ErrNoRows updating a deleted entry got %v", err)
+	}
+
+	err = DeleteCollectionCard(dbh, coll.ID+1, card.ID)
+	if err != sql.ErrNoRows {
+		t.Fatalf("Expected sql.ErrNoRows deleting from another collection got %v", err)
+	}
+	err = DeleteCollection(dbh, coll.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cards, err = CollectionCards(dbh, coll.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 0 {
+		t.Fatalf("Expected deleting the collection to delete its cards got %+v", cards)
+	}
+}
+
+func TestCollectionCardsAfterPrune(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	sets := map[string]mtgjson.Set{
+		"LEA": {Code: "LEA", Cards: []*mtgjson.Card{{MTGJsonID: "1", SetCode: "LEA", Name: "Shivan Dragon", Number: "174"}}},
+		"M10": {Code: "M10", Cards: []*mtgjson.Card{{MTGJsonID: "2", SetCode: "M10", Name: "Air Elemental", Number: "42"}}},
+	}
+	err := SaveCards(dbh, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll, err := CreateCollection(dbh, "Binder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddCollectionCards(dbh, coll.ID, []CollectionCard{{MTGJsonID: "1", Quantity: 1}, {MTGJsonID: "2", Quantity: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = PruneSets(dbh, []string{"LEA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cards, err := CollectionCards(dbh, coll.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 2 {
+		t.Fatalf("Expected cards whose printing was pruned to stay in the collection got %+v", cards)
+	}
+	if cards[0].Name != "Air Elemental" || cards[0].SetCode != "" || cards[0].Quantity != 2 {
+		t.Fatalf("Expected the pruned Air Elemental by its saved name got %+v", cards[0])
+	}
+	if cards[1].Name != "Shivan Dragon" || cards[1].SetCode != "LEA" {
+		t.Fatalf("Expected LEA Shivan Dragon got %+v", cards[1])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"unicode"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/hobeone/gomigrate"
@@ -17,10 +18,24 @@ var (
 		"text":   true,
 		"flavor": true,
 	}
+
+	// selectorCols are search parameters that need more than a plain
+	// column = ? comparison.
+	selectorCols = map[string]string{
+		"format":          "mtg_json_id IN (SELECT mtg_json_id FROM legality WHERE format = ? AND legality IN ('legal', 'restricted'))",
+		"set":             "lower(set_code) = ?",
+		"block":           `set_code IN (SELECT code FROM "set" WHERE lower(block) = ?)`,
+		"settype":         `set_code IN (SELECT code FROM "set" WHERE lower(type) = ?)`,
+		"released_before": "release_date < ?",
+		"released_after":  "release_date > ?",
+	}
 )
 
 func genSelector(column string, values []string) (string, []string) {
 	sel := fmt.Sprintf("%s = ?", column)
+	if custom, ok := selectorCols[column]; ok {
+		sel = custom
+	}
 
 	if _, ok := wildcardCols[column]; ok {
 		sel = fmt.Sprintf("%s LIKE ?", column)
@@ -37,10 +52,19 @@ func genSelector(column string, values []string) (string, []string) {
 	return final, values
 }
 
-// SearchCards implements advanced searching of the card db
-func SearchCards(db *Handle, columns []string, values [][]string) ([]mtgjson.Card, error) {
-	selectors, selectvalues := []string{}, []string{}
+// SearchCards implements advanced searching of the card db.  It returns the
+// matching cards and the total number of matches ignoring opts.Limit.  When
+// the db supports it name, text and flavor are searched with the full text
+// index and the results ordered by relevance unless opts.Sort is set.
+func SearchCards(db *Handle, columns []string, values [][]string, opts SearchOptions) ([]mtgjson.Card, int, error) {
+	selectors, selectvalues, matches := []string{}, []string{}, []string{}
 	for i, col := range columns {
+		if db.fts && wildcardCols[col] {
+			if m := ftsMatch(col, values[i]); m != "" {
+				matches = append(matches, m)
+				continue
+			}
+		}
 		selector, vals := genSelector(col, values[i])
 		selectors = append(selectors, selector)
 		selectvalues = append(selectvalues, vals...)
@@ -49,11 +73,213 @@ func SearchCards(db *Handle, columns []string, values [][]string) ([]mtgjson.Car
 	for i, d := range selectvalues {
 		interfaceSlice[i] = d
 	}
-	queryString := "SELECT * from card WHERE " + strings.Join(selectors, " AND ") + "ORDER BY release_date,name"
-	logrus.Infof("query: %s, values: ", queryString, selectvalues)
+	where := "1"
+	if len(selectors) > 0 {
+		where = strings.Join(selectors, " AND ")
+	}
+	return selectCards(db, where, interfaceSlice, strings.Join(matches, " AND "), opts)
+}
+
+// ftsMatch builds an FTS5 query matching any of values in column.  Words
+// match as prefixes, so "gob" finds Goblin, and values wrapped in double
+// quotes match as a phrase.
+func ftsMatch(column string, values []string) string {
+	isSep := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}
+	phrases := []string{}
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		words := strings.FieldsFunc(v, isSep)
+		if len(words) == 0 {
+			continue
+		}
+		if len(v) > 1 && strings.HasPrefix(v, `"`) && strings.HasSuffix(v, `"`) {
+			phrases = append(phrases, fmt.Sprintf(`%s : "%s"`, column, strings.Join(words, " ")))
+			continue
+		}
+		for i, w := range words {
+			words[i] = `"` + w + `"*`
+		}
+		phrases = append(phrases, fmt.Sprintf("%s : (%s)", column, strings.Join(words, " ")))
+	}
+	if len(phrases) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(phrases, " OR ") + ")"
+}
+
+// SearchOptions controls the ordering and size of search results.
+type SearchOptions struct {
+	// Sort is one of the keys of SortColumns, defaults to release date.
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+	// Unique returns only the most recent printing of each card name.
+	Unique bool
+}
+
+// SortColumns maps the user facing sort keys to their SQL expressions.
+var SortColumns = map[string]string{
+	"name":     "name",
+	"cmc":      "cmc",
+	"date":     "release_date",
+	"released": "release_date",
+	"rarity":   rarityRank,
+	"power":    "CAST(power AS REAL)",
+	"number":   "CAST(number AS INTEGER)",
+}
+
+// orderBy returns the ORDER BY and LIMIT clauses, sorting by defaultCol if
+// no sort order was given.
+func (o SearchOptions) orderBy(defaultCol string) (string, error) {
+	col := defaultCol
+	if o.Sort != "" {
+		c, ok := SortColumns[o.Sort]
+		if !ok {
+			return "", fmt.Errorf("Unknown sort order '%s'", o.Sort)
+		}
+		col = c
+	}
+	dir := "ASC"
+	if o.Desc {
+		dir = "DESC"
+	}
+	clause := fmt.Sprintf(" ORDER BY %s %s, name ASC", col, dir)
+	if o.Limit > 0 {
+		clause += fmt.Sprintf(" LIMIT %d OFFSET %d", o.Limit, o.Offset)
+	}
+	return clause, nil
+}
+
+// ftsJoin restricts the card table to rows matching a full text query,
+// adding their rank and a highlighted snippet.
+const ftsJoin = `card JOIN (SELECT rowid, rank, snippet(card_fts, -1, '<b>', '</b>', '…', 16) AS snippet
+FROM card_fts WHERE card_fts MATCH ?) m ON m.rowid = card.id`
+
+// selectCards returns the cards matching where and, if not empty, the full
+// text query match along with the total number of matches.
+func selectCards(db *Handle, where string, args []interface{}, match string, opts SearchOptions) ([]mtgjson.Card, int, error) {
+	from, cols, defaultSort := "card", "card.*", "release_date"
+	if match != "" {
+		from, cols, defaultSort = ftsJoin, "card.*, m.snippet", "m.rank"
+		args = append([]interface{}{match}, args...)
+	}
+	order, err := opts.orderBy(defaultSort)
+	if err != nil {
+		return nil, 0, err
+	}
+	if opts.Unique {
+		// SQLite returns the bare id column from the row holding the MAX()
+		where = "id IN (SELECT id FROM (SELECT id, MAX(release_date) FROM card WHERE " + where + " GROUP BY search_name))"
+	}
+	queryString := "SELECT " + cols + " FROM " + from + " WHERE " + where + order
+	logrus.Infof("query: %s, values: %v", queryString, args)
 	cards := []mtgjson.Card{}
-	err := db.db.Select(&cards, queryString, interfaceSlice...)
-	return cards, err
+	err = db.db.Select(&cards, queryString, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Only count when the page doesn't already tell us the total
+	total := len(cards)
+	if opts.Limit > 0 && (total == opts.Limit || (total == 0 && opts.Offset > 0)) {
+		err = db.db.Get(&total, "SELECT count(*) FROM "+from+" WHERE "+where, args...)
+	} else {
+		total += opts.Offset
+	}
+	return cards, total, err
+}
+
+// SearchCardsQuery parses a query expression (see ParseQuery) and returns
+// the matching cards and the total number of matches ignoring opts.Limit.
+func SearchCardsQuery(db *Handle, query string, opts SearchOptions) ([]mtgjson.Card, int, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	return SearchCardsParsed(db, q, opts)
+}
+
+// SearchCardsParsed is like SearchCardsQuery for an already parsed query
+func SearchCardsParsed(db *Handle, q Query, opts SearchOptions) ([]mtgjson.Card, int, error) {
+	where, args := q.SQL()
+	return selectCards(db, where, args, "", opts)
+}
+
+// AddLegalities fills in the Legalities of the given cards from the
+// legality table.
+func AddLegalities(dbh *Handle, cards ...*mtgjson.Card) error {
+	byID := map[string][]*mtgjson.Card{}
+	ids := []interface{}{}
+	for _, card := range cards {
+		if _, ok := byID[card.MTGJsonID]; !ok {
+			ids = append(ids, card.MTGJsonID)
+		}
+		byID[card.MTGJsonID] = append(byID[card.MTGJsonID], card)
+		card.Legalities = []mtgjson.Legality{}
+	}
+	// Stay well under SQLite's limit on the number of query parameters
+	chunk := 500
+	for start := 0; start < len(ids); start += chunk {
+		end := start + chunk
+		if end > len(ids) {
+			end = len(ids)
+		}
+		rows := []struct {
+			MTGJsonID string `db:"mtg_json_id"`
+			mtgjson.Legality
+		}{}
+		query, args, err := sqlx.In("SELECT mtg_json_id, format, legality FROM legality WHERE mtg_json_id IN (?) ORDER BY format", ids[start:end])
+		if err != nil {
+			return err
+		}
+		err = dbh.db.Select(&rows, query, args...)
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			for _, card := range byID[r.MTGJsonID] {
+				card.Legalities = append(card.Legalities, r.Legality)
+			}
+		}
+	}
+	return nil
+}
+
+// RulingsByName returns the rulings for the card with the given name, oldest
+// first.
+func RulingsByName(dbh *Handle, name string) ([]mtgjson.Ruling, error) {
+	rulings := []mtgjson.Ruling{}
+	err := dbh.db.Select(&rulings, "SELECT date, text FROM ruling WHERE search_name = ? ORDER BY date, id", normalizeName(name))
+	return rulings, err
+}
+
+// Sets returns all sets ordered by release date
+func Sets(dbh *Handle) ([]mtgjson.Set, error) {
+	sets := []mtgjson.Set{}
+	err := dbh.db.Select(&sets, `SELECT * FROM "set" ORDER BY release_date, code`)
+	return sets, err
+}
+
+// SetByCode returns the set with the given code, ignoring case
+func SetByCode(dbh *Handle, code string) (*mtgjson.Set, error) {
+	set := mtgjson.Set{}
+	err := dbh.db.Get(&set, `SELECT * FROM "set" WHERE lower(code) = lower(?)`, code)
+	return &set, err
+}
+
+// CardsInSet returns a page of the cards in a set along with the total
+// number of cards in the set.
+func CardsInSet(dbh *Handle, code string, opts SearchOptions) ([]mtgjson.Card, int, error) {
+	return selectCards(dbh, "set_code = ?", []interface{}{code}, "", opts)
+}
+
+// Formats returns the names of all formats with legality information
+func Formats(dbh *Handle) ([]string, error) {
+	formats := []string{}
+	err := dbh.db.Select(&formats, "SELECT DISTINCT format FROM legality ORDER BY format")
+	return formats, err
 }
 
 // CardByMTGJsonID returns the first card found with the given mtgjson.com id
@@ -71,129 +297,110 @@ func CardByName(dbh *Handle, name string) (*mtgjson.Card, error) {
 	return &card, err
 }
 
+// CardPrinting returns the printing of the named card with the given set
+// code and, if not empty, collector number.
+func CardPrinting(dbh *Handle, name, set, number string) (*mtgjson.Card, error) {
+	card := mtgjson.Card{}
+	err := dbh.db.Get(&card, "SELECT * FROM card WHERE search_name = ? AND lower(set_code) = ? AND (? = '' OR number = ?) LIMIT 1",
+		normalizeName(name), strings.ToLower(set), number, number)
+	return &card, err
+}
+
 func normalizeName(name string) string {
 	norm := strings.ToLower(name)
 	return norm
 }
 
-//SaveCards saves all given cards to the db
-func SaveCards(db *Handle, sets map[string]mtgjson.Set) error {
-	cardInsert := `INSERT INTO card (
-"mtg_json_id",
-"set_code",
-"set_name",
-"release_date",
-"layout",
-"power",
-"toughness",
-"loyalty",
-"hand",
-"life",
-"cmc",
-"mana_cost",
-"name",
-"names",
-"search_name",
-"type",
-"super_types",
-"types",
-"sub_types",
-"colors",
-"rarity",
-"text",
-"timeshifted",
-"reserved",
-"starter",
-"flavor",
-"multiverse_id",
-"number",
-"source",
-"watermark",
-"artist",
-"image_name") VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`
-	for _, set := range sets {
-		tx := db.db.MustBegin()
-		logrus.Infof("Adding %d cards from %s: %s", len(set.Cards), set.Code, set.Name)
-		for _, card := range set.Cards {
-			tx.MustExec(cardInsert,
-				card.MTGJsonID,
-				card.SetCode,
-				card.SetName,
-				card.ReleaseDate,
-				card.Layout,
-				card.Power,
-				card.Toughness,
-				card.Loyalty,
-				card.Hand,
-				card.Life,
-				card.CMC,
-				card.ManaCost,
-				card.Name,
-				card.Names,
-				normalizeName(card.Name),
-				card.Type,
-				card.Supertypes,
-				card.Types,
-				card.Subtypes,
-				card.Colors,
-				card.Rarity,
-				card.Text,
-				card.Timeshifted,
-				card.Reserved,
-				card.Starter,
-				card.Flavor,
-				card.MultiverseID,
-				card.Number,
-				card.Source,
-				card.Watermark,
-				card.Artist,
-				card.ImageName)
-		}
-		err := tx.Commit()
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 // Handle controls access to the database and makes sure only one
 // operation is in process at a time.
 type Handle struct {
 	db        *sqlx.DB
 	logger    logrus.FieldLogger
 	syncMutex sync.Mutex
+	// fts is true if the card_fts full text index exists and SQLite was
+	// built with FTS5 so it can be used, see ftsMigrations and checkFTS
+	fts bool
+	// ftsCompiled is true if SQLite was built with FTS5
+	ftsCompiled bool
+	// ftsTable is true if the card_fts table exists
+	ftsTable bool
+
+	namesMutex sync.Mutex
+	names      *NameIndex
+}
+
+// Names returns an index of all card names, building it on first use.
+func (d *Handle) Names() (*NameIndex, error) {
+	d.namesMutex.Lock()
+	defer d.namesMutex.Unlock()
+	if d.names == nil {
+		names, err := NewNameIndex(d)
+		if err != nil {
+			return nil, err
+		}
+		d.names = names
+	}
+	return d.names, nil
+}
+
+// resetNames drops the name index after cards are added or removed
+func (d *Handle) resetNames() {
+	d.namesMutex.Lock()
+	d.names = nil
+	d.namesMutex.Unlock()
 }
 
 // NewDBHandle creates a new DBHandle
 //	dbPath: the path to the database to use.
 //	verbose: when true database accesses are logged to stdout
-func NewDBHandle(dbPath string, verbose bool, logger logrus.FieldLogger) *Handle {
+func NewDBHandle(dbPath string, verbose bool, logger logrus.FieldLogger) (*Handle, error) {
 	constructedPath := fmt.Sprintf("file:%s?cache=shared&mode=rwc", dbPath)
-	db := openDB("sqlite3", constructedPath, verbose, logger)
-	err := setupDB(db)
+	db, err := openDB("sqlite3", constructedPath, verbose, logger)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	return &Handle{
-		db:     db,
-		logger: logger,
+	err = setupDB(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("db: error setting up database %s: %s", dbPath, err)
 	}
+	d := &Handle{
+		db:          db,
+		logger:      logger,
+		ftsCompiled: hasFTS5(db),
+	}
+	err = d.checkFTS()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("db: error building full text index for %s: %s", dbPath, err)
+	}
+	return d, nil
 }
-func openDB(dbType string, dbArgs string, verbose bool, logger logrus.FieldLogger) *sqlx.DB {
+
+// MustNewDBHandle is like NewDBHandle but panics on error
+func MustNewDBHandle(dbPath string, verbose bool, logger logrus.FieldLogger) *Handle {
+	d, err := NewDBHandle(dbPath, verbose, logger)
+	if err != nil {
+		panic(err.Error())
+	}
+	return d
+}
+
+func openDB(dbType string, dbArgs string, verbose bool, logger logrus.FieldLogger) (*sqlx.DB, error) {
 	logger.Infof("db: opening database %s:%s", dbType, dbArgs)
 	// Error only returns from this if it is an unknown driver.
 	db, err := sqlx.Connect("sqlite3", dbArgs)
 
 	if err != nil {
-		panic(fmt.Sprintf("Error connecting to %s database %s: %s", dbType, dbArgs, err.Error()))
+		return nil, fmt.Errorf("Error connecting to %s database %s: %s", dbType, dbArgs, err.Error())
 	}
 	// Actually test that we have a working connection
 	err = db.Ping()
 	if err != nil {
-		panic(fmt.Sprintf("db: error connecting to database: %s", err.Error()))
+		db.Close()
+		return nil, fmt.Errorf("db: error connecting to database: %s", err.Error())
 	}
-	return db
+	return db, nil
 }
 
 func setupDB(db *sqlx.DB) error {
@@ -213,25 +420,88 @@ func setupDB(db *sqlx.DB) error {
 	return nil
 }
 
+// hasFTS5 checks if SQLite was compiled with the FTS5 extension.  go-sqlite3
+// only includes it when built with -tags sqlite_fts5.
+func hasFTS5(db *sqlx.DB) bool {
+	used := false
+	err := db.Get(&used, "SELECT sqlite_compileoption_used('ENABLE_FTS5')")
+	return err == nil && used
+}
+
+// hasTable checks if the named table exists in the db
+func hasTable(db *sqlx.DB, name string) bool {
+	n := 0
+	err := db.Get(&n, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name)
+	return err == nil && n > 0
+}
+
+// checkFTS looks for the card_fts full text index and decides whether
+// searches use it.  If SQLite has FTS5 but a db with cards has no index,
+// because it was migrated by a build without FTS5, the index is built.
+func (d *Handle) checkFTS() error {
+	d.ftsTable = hasTable(d.db, "card_fts")
+	if d.ftsCompiled && !d.ftsTable && hasTable(d.db, "card") {
+		d.logger.Infof("db: building card full text index")
+		ftsMigs := []*gomigrate.Migration{}
+		for _, mig := range SchemaMigrations() {
+			if ftsMigrations[mig.ID] {
+				ftsMigs = append(ftsMigs, mig)
+			}
+		}
+		err := d.migrate(ftsMigs)
+		if err != nil {
+			return err
+		}
+		// The migrations may have been recorded as run by a db whose index
+		// was later dropped.
+		if !hasTable(d.db, "card_fts") {
+			for _, mig := range ftsMigs {
+				_, err = d.db.Exec(mig.Up)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		d.ftsTable = true
+	}
+	d.fts = d.ftsCompiled && d.ftsTable
+	return nil
+}
+
+// checkFTSWritable returns an error if the db has a full text index that
+// this build can't keep up to date because SQLite doesn't have FTS5.
+func (d *Handle) checkFTSWritable() error {
+	if d.ftsTable && !d.ftsCompiled {
+		return fmt.Errorf("db has a full text index but SQLite was built without FTS5 to update it, rebuild with -tags sqlite_fts5")
+	}
+	return nil
+}
+
 // NewMemoryDBHandle creates a new in memory database.  Only used for testing.
 // The name of the database is a random string so multiple tests can run in
 // parallel with their own database.
-func NewMemoryDBHandle(verbose bool, logger logrus.FieldLogger, loadFixtures bool) *Handle {
-	db := openDB("sqlite3", ":memory:", verbose, logger)
+func NewMemoryDBHandle(verbose bool, logger logrus.FieldLogger, loadFixtures bool) (*Handle, error) {
+	db, err := openDB("sqlite3", ":memory:", verbose, logger)
+	if err != nil {
+		return nil, err
+	}
 
-	err := setupDB(db)
+	err = setupDB(db)
 	if err != nil {
-		panic(err.Error())
+		db.Close()
+		return nil, err
 	}
 
 	d := &Handle{
-		db:     db,
-		logger: logger,
+		db:          db,
+		logger:      logger,
+		ftsCompiled: hasFTS5(db),
 	}
 
 	err = d.Migrate(SchemaMigrations())
 	if err != nil {
-		panic(err)
+		db.Close()
+		return nil, err
 	}
 	/*
 		if loadFixtures {
@@ -242,6 +512,15 @@ func NewMemoryDBHandle(verbose bool, logger logrus.FieldLogger, loadFixtures boo
 			}
 		}
 	*/
+	return d, nil
+}
+
+// MustNewMemoryDBHandle is like NewMemoryDBHandle but panics on error
+func MustNewMemoryDBHandle(verbose bool, logger logrus.FieldLogger, loadFixtures bool) *Handle {
+	d, err := NewMemoryDBHandle(verbose, logger, loadFixtures)
+	if err != nil {
+		panic(err.Error())
+	}
 	return d
 }
 
@@ -309,10 +588,190 @@ CREATE UNIQUE INDEX mtgjson_idx on card (mtg_json_id)
 				"DROP TABLE card",
 				`,
 	},
+	{
+		ID:   101,
+		Name: "Legalities",
+		Up: `CREATE TABLE legality (
+  "id" INTEGER PRIMARY KEY,
+  "mtg_json_id" VARCHAR(255),
+  "format" VARCHAR(64),
+  "legality" VARCHAR(32)
+);
+CREATE INDEX legality_card_idx on legality (mtg_json_id);
+CREATE UNIQUE INDEX legality_format_idx on legality (format, mtg_json_id)
+`,
+		Down: `DROP TABLE legality`,
+	},
+	{
+		ID:   102,
+		Name: "Color Identity",
+		Up:   `ALTER TABLE card ADD COLUMN "color_identity" VARCHAR(32)`,
+	},
+	{
+		ID:   103,
+		Name: "Rulings",
+		Up: `CREATE TABLE ruling (
+  "id" INTEGER PRIMARY KEY,
+  "search_name" VARCHAR(255),
+  "date" VARCHAR(10),
+  "text" TEXT
+);
+CREATE UNIQUE INDEX ruling_name_idx on ruling (search_name, date, text)
+`,
+		Down: `DROP TABLE ruling`,
+	},
+	{
+		ID:   104,
+		Name: "Sets",
+		Up: `CREATE TABLE "set" (
+  "code" VARCHAR(8) PRIMARY KEY,
+  "name" VARCHAR(255),
+  "release_date" VARCHAR(10),
+  "border" VARCHAR(32),
+  "type" VARCHAR(32),
+  "block" VARCHAR(255),
+  "gatherer_code" VARCHAR(8),
+  "old_code" VARCHAR(8),
+  "magic_cards_info_code" VARCHAR(8),
+  "online_only" BOOLEAN
+);
+CREATE INDEX set_card_idx on card (set_code)
+`,
+		Down: `DROP TABLE "set"`,
+	},
+	{
+		ID:   105,
+		Name: "Card full text search",
+		Up: `CREATE VIRTUAL TABLE card_fts USING fts5(name, text, flavor, tokenize = 'unicode61 remove_diacritics 2');
+INSERT INTO card_fts (rowid, name, text, flavor) SELECT id, name, text, flavor FROM card
+`,
+		Down: `DROP TABLE card_fts`,
+	},
+	{
+		ID:   106,
+		Name: "Collections",
+		Up: `CREATE TABLE collection (
+  "id" INTEGER PRIMARY KEY,
+  "name" VARCHAR(255) NOT NULL,
+  "created" DATETIME
+);
+CREATE TABLE collection_card (
+  "id" INTEGER PRIMARY KEY,
+  "collection_id" INTEGER NOT NULL REFERENCES collection (id),
+  "mtg_json_id" VARCHAR(255) NOT NULL,
+  "quantity" INTEGER NOT NULL,
+  "foil" BOOLEAN NOT NULL DEFAULT 0,
+  "condition" VARCHAR(8) NOT NULL,
+  "language" VARCHAR(32) NOT NULL
+);
+CREATE UNIQUE INDEX collection_card_idx on collection_card (collection_id, mtg_json_id, foil, condition, language)
+`,
+		Down: `DROP TABLE collection_card;
+DROP TABLE collection`,
+	},
+	{
+		ID:   107,
+		Name: "Saved decks",
+		Up: `CREATE TABLE deck (
+  "id" INTEGER PRIMARY KEY,
+  "name" VARCHAR(255) NOT NULL,
+  "created" DATETIME
+);
+CREATE UNIQUE INDEX deck_name_idx on deck (name);
+CREATE TABLE deck_version (
+  "id" INTEGER PRIMARY KEY,
+  "deck_id" INTEGER NOT NULL REFERENCES deck (id),
+  "version" INTEGER NOT NULL,
+  "note" TEXT NOT NULL DEFAULT '',
+  "created" DATETIME
+);
+CREATE UNIQUE INDEX deck_version_idx on deck_version (deck_id, version);
+CREATE TABLE deck_card (
+  "id" INTEGER PRIMARY KEY,
+  "version_id" INTEGER NOT NULL REFERENCES deck_version (id),
+  "section" VARCHAR(32) NOT NULL,
+  "mtg_json_id" VARCHAR(255) NOT NULL DEFAULT '',
+  "name" VARCHAR(255) NOT NULL,
+  "count" INTEGER NOT NULL
+);
+CREATE INDEX deck_card_version_idx on deck_card (version_id)
+`,
+		Down: `DROP TABLE deck_card;
+DROP TABLE deck_version;
+DROP TABLE deck`,
+	},
+	{
+		ID:   108,
+		Name: "Prices",
+		Up: `CREATE TABLE price (
+  "mtg_json_id" VARCHAR(255) NOT NULL,
+  "vendor" VARCHAR(32) NOT NULL,
+  "finish" VARCHAR(16) NOT NULL,
+  "date" VARCHAR(10) NOT NULL,
+  "price" FLOAT NOT NULL,
+  "currency" VARCHAR(3) NOT NULL,
+  PRIMARY KEY (mtg_json_id, vendor, finish, date)
+);
+CREATE INDEX price_vendor_date_idx on price (vendor, date)
+`,
+		Down: `DROP TABLE price`,
+	},
+	{
+		ID:   109,
+		Name: "Price history",
+		Up: `CREATE TABLE price_history (
+  "mtg_json_id" VARCHAR(255) NOT NULL,
+  "vendor" VARCHAR(32) NOT NULL,
+  "finish" VARCHAR(16) NOT NULL,
+  "date" VARCHAR(10) NOT NULL,
+  "price" FLOAT NOT NULL,
+  "currency" VARCHAR(3) NOT NULL,
+  PRIMARY KEY (mtg_json_id, vendor, finish, date)
+);
+CREATE INDEX price_history_vendor_date_idx on price_history (vendor, date);
+INSERT INTO price_history SELECT mtg_json_id, vendor, finish, date, price, currency FROM price;
+DELETE FROM price WHERE date < (SELECT MAX(date) FROM price latest
+  WHERE latest.mtg_json_id = price.mtg_json_id AND latest.vendor = price.vendor AND latest.finish = price.finish)
+`,
+		Down: `DROP TABLE price_history`,
+	},
+	{
+		ID:   110,
+		Name: "Collection card names",
+		Up: `ALTER TABLE collection_card ADD COLUMN "name" VARCHAR(255) NOT NULL DEFAULT '';
+UPDATE collection_card SET name = COALESCE((SELECT name FROM card WHERE card.mtg_json_id = collection_card.mtg_json_id), '')
+`,
+		Down: `ALTER TABLE collection_card DROP COLUMN name`,
+	},
+}
+
+// ftsMigrations need FTS5 and are skipped if SQLite doesn't have it.  They
+// are applied the next time the db is migrated by a build that does.
+var ftsMigrations = map[uint64]bool{
+	105: true,
 }
 
 // Migrate uses the migrations at the given path to update the database.
 func (d *Handle) Migrate(m []*gomigrate.Migration) error {
+	err := d.migrate(m)
+	if err != nil {
+		return err
+	}
+	return d.checkFTS()
+}
+
+func (d *Handle) migrate(m []*gomigrate.Migration) error {
+	if !d.ftsCompiled {
+		supported := []*gomigrate.Migration{}
+		for _, mig := range m {
+			if ftsMigrations[mig.ID] {
+				d.logger.Warnf("Skipping migration %d (%s): SQLite was built without FTS5", mig.ID, mig.Name)
+				continue
+			}
+			supported = append(supported, mig)
+		}
+		m = supported
+	}
 	migrator, err := gomigrate.NewMigratorWithMigrations(d.db.DB, gomigrate.Sqlite3{}, m)
 	if err != nil {
 		return err
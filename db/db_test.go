@@ -0,0 +1,323 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+func TestRulingsDeduplicated(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	rulings := []mtgjson.Ruling{
+		{Date: "2004-10-04", Text: "It can target itself."},
+		{Date: "2009-10-01", Text: "Damage is dealt by the spell."},
+	}
+	sets := map[string]mtgjson.Set{
+		"LEA": {
+			Code:  "LEA",
+			Cards: []*mtgjson.Card{{MTGJsonID: "1", Name: "Fireball", Rulings: rulings}},
+		},
+		"M10": {
+			Code:  "M10",
+			Cards: []*mtgjson.Card{{MTGJsonID: "2", Name: "Fireball", Rulings: rulings}},
+		},
+	}
+	err := SaveCards(dbh, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := RulingsByName(dbh, "FIREBALL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("Expected 2 rulings got %d", len(found))
+	}
+	if found[0].Date != "2004-10-04" {
+		t.Fatalf("Expected oldest ruling first, got %v", found[0])
+	}
+}
+
+func TestSaveSetReload(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	set := mtgjson.Set{
+		Code: "TST",
+		Cards: []*mtgjson.Card{
+			{MTGJsonID: "1", SetCode: "TST", Name: "Shock", Text: "Shock deals 2 damage to any target.",
+				Legalities: []mtgjson.Legality{{Format: "modern", Legality: "legal"}}},
+			{MTGJsonID: "2", SetCode: "TST", Name: "Opt"},
+			{MTGJsonID: "3", SetCode: "TST", Name: "Ponder"},
+		},
+	}
+	summary, err := SaveSet(dbh, set, SaveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Added != 3 {
+		t.Fatalf("Expected 3 added cards: %s", summary)
+	}
+
+	summary, err = SaveSet(dbh, set, SaveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Unchanged != 3 || summary.Added+summary.Updated+summary.Removed != 0 {
+		t.Fatalf("Expected reload to change nothing: %s", summary)
+	}
+
+	set.Cards[0].Legalities[0].Legality = "banned"
+	set.Cards = append(set.Cards[:2], &mtgjson.Card{MTGJsonID: "4", SetCode: "TST", Name: "Preordain"})
+	summary, err = SaveSet(dbh, set, SaveOptions{Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := SetSummary{Code: "TST", Added: 1, Updated: 1, Unchanged: 1, Removed: 1}
+	if !reflect.DeepEqual(*summary, expected) {
+		t.Fatalf("Expected %s got %s", expected, summary)
+	}
+
+	cards, _, err := SearchCardsQuery(dbh, "banned:modern", SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Name != "Shock" {
+		t.Fatalf("Expected updated legality for Shock, got %v", cards)
+	}
+
+	pruned, err := PruneSets(dbh, []string{"OTHER"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 1 || pruned[0].Removed != 3 {
+		t.Fatalf("Expected 3 cards pruned, got %v", pruned)
+	}
+}
+
+func TestSaveSetErrors(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	// The duplicate legality violates the unique format index
+	set := mtgjson.Set{
+		Code: "TST",
+		Cards: []*mtgjson.Card{
+			{MTGJsonID: "1", SetCode: "TST", Name: "Opt"},
+			{MTGJsonID: "2", SetCode: "TST", Name: "Broken",
+				Legalities: []mtgjson.Legality{{Format: "modern", Legality: "legal"}, {Format: "modern", Legality: "banned"}}},
+		},
+	}
+
+	_, err := SaveSet(dbh, set, SaveOptions{})
+	lerr, ok := err.(*LoadError)
+	if !ok {
+		t.Fatalf("Expected a *LoadError got %v", err)
+	}
+	if lerr.Set != "TST" || lerr.Card != "Broken" {
+		t.Fatalf("Expected error to name the set and card: %s", lerr)
+	}
+	cards, _, err := SearchCardsQuery(dbh, "s:tst", SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 0 {
+		t.Fatalf("Expected the set to be rolled back, got %v", cards)
+	}
+
+	summary, err := SaveSet(dbh, set, SaveOptions{ContinueOnError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Added != 1 || len(summary.Skipped) != 1 || summary.Skipped[0].Card != "Broken" {
+		t.Fatalf("Expected Broken to be skipped: %s", summary)
+	}
+	cards, _, err = SearchCardsQuery(dbh, "s:tst", SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Name != "Opt" {
+		t.Fatalf("Expected only Opt to be saved, got %v", cards)
+	}
+}
+
+func TestSets(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	sets := []mtgjson.Set{
+		{Code: "LEA", Name: "Limited Edition Alpha", ReleaseDate: "1993-08-05", SetType: "core", Border: "black",
+			Cards: []*mtgjson.Card{{MTGJsonID: "1", SetCode: "LEA", Name: "Shivan Dragon", ReleaseDate: time.Date(1993, 8, 5, 0, 0, 0, 0, time.UTC)}}},
+		{Code: "APC", Name: "Apocalypse", ReleaseDate: "2001-06-04", SetType: "expansion", Block: "Invasion",
+			Cards: []*mtgjson.Card{{MTGJsonID: "2", SetCode: "APC", Name: "Fire", Number: "128", ReleaseDate: time.Date(2001, 6, 4, 0, 0, 0, 0, time.UTC)},
+				{MTGJsonID: "3", SetCode: "APC", Name: "Vindicate", Number: "12", ReleaseDate: time.Date(2001, 6, 4, 0, 0, 0, 0, time.UTC)}}},
+	}
+	for _, set := range sets {
+		_, err := SaveSet(dbh, set, SaveOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := Sets(dbh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].Code != "LEA" || found[1].Block != "Invasion" {
+		t.Fatalf("Unexpected sets: %v", found)
+	}
+
+	set, err := SetByCode(dbh, "apc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Name != "Apocalypse" || set.SetType != "expansion" {
+		t.Fatalf("Unexpected set: %v", set)
+	}
+
+	cards, total, err := CardsInSet(dbh, "APC", SearchOptions{Sort: "number", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(cards) != 1 || cards[0].Name != "Vindicate" {
+		t.Fatalf("Expected first page with Vindicate of 2 cards, got %d %v", total, cards)
+	}
+
+	cards, _, err = SearchCards(dbh, []string{"block", "released_after"}, [][]string{{"invasion"}, {"2000-01-01"}}, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 2 {
+		t.Fatalf("Expected 2 Invasion block cards, got %v", cards)
+	}
+	cards, _, err = SearchCards(dbh, []string{"set"}, [][]string{{"lea"}}, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Name != "Shivan Dragon" {
+		t.Fatalf("Expected only Shivan Dragon, got %v", cards)
+	}
+}
+
+func TestFTSMatch(t *testing.T) {
+	tests := []struct {
+		values   []string
+		expected string
+	}{
+		{[]string{"gob"}, `(name : ("gob"*))`},
+		{[]string{"urza's", "jace"}, `(name : ("urza"* "s"*) OR name : ("jace"*))`},
+		{[]string{`"lightning bolt"`}, `(name : "lightning bolt")`},
+		{[]string{"!!"}, ""},
+	}
+	for _, test := range tests {
+		if m := ftsMatch("name", test.values); m != test.expected {
+			t.Errorf("Expected %v to give %s got %s", test.values, test.expected, m)
+		}
+	}
+}
+
+func TestSearchCardsFullText(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	if !dbh.fts {
+		t.Skip("SQLite built without FTS5, use -tags sqlite_fts5")
+	}
+	set := mtgjson.Set{
+		Code: "TST",
+		Cards: []*mtgjson.Card{
+			{MTGJsonID: "1", SetCode: "TST", Name: "Goblin King", Text: "Other Goblin creatures get +1/+1."},
+			{MTGJsonID: "2", SetCode: "TST", Name: "Raging Goblin", Text: "Haste"},
+			{MTGJsonID: "3", SetCode: "TST", Name: "Lightning Bolt", Text: "Lightning Bolt deals 3 damage to any target."},
+			{MTGJsonID: "4", SetCode: "TST", Name: "Dandân", Text: "Dandân can't attack unless defending player controls an Island."},
+		},
+	}
+	_, err := SaveSet(dbh, set, SaveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cards, total, err := SearchCards(dbh, []string{"text"}, [][]string{{"goblin"}}, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || cards[0].Name != "Goblin King" || cards[0].Snippet != "Other <b>Goblin</b> creatures get +1/+1." {
+		t.Fatalf("Expected Goblin King with a snippet, got %v", cards)
+	}
+
+	cards, _, err = SearchCards(dbh, []string{"name"}, [][]string{{"dandan"}}, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Name != "Dandân" {
+		t.Fatalf("Expected a diacritic insensitive match, got %v", cards)
+	}
+
+	set.Cards[2].Text = "Lightning Bolt deals 3 damage to any target. Goblin approved."
+	set.Cards = set.Cards[:3]
+	_, err = SaveSet(dbh, set, SaveOptions{Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cards, _, err = SearchCards(dbh, []string{"text", "name"}, [][]string{{"gob"}, {`"lightning bolt"`}}, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Name != "Lightning Bolt" {
+		t.Fatalf("Expected the updated Lightning Bolt, got %v", cards)
+	}
+	cards, _, err = SearchCards(dbh, []string{"name"}, [][]string{{"dandan"}}, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 0 {
+		t.Fatalf("Expected pruned cards to be removed from the index, got %v", cards)
+	}
+}
+
+func TestCheckFTS(t *testing.T) {
+	db, err := openDB("sqlite3", ":memory:", false, logrus.StandardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Migrated and loaded by a build without FTS5
+	dbh := &Handle{db: db, logger: logrus.StandardLogger()}
+	err = dbh.Migrate(SchemaMigrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbh.fts || dbh.ftsTable {
+		t.Fatalf("Expected no full text index without FTS5")
+	}
+	set := mtgjson.Set{Code: "TST", Cards: []*mtgjson.Card{{MTGJsonID: "1", SetCode: "TST", Name: "Goblin King"}}}
+	_, err = SaveSet(dbh, set, SaveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbh.ftsCompiled = hasFTS5(db)
+	if !dbh.ftsCompiled {
+		// A build without FTS5 can't update an index made by one with it
+		dbh.ftsTable = true
+		_, err = SaveSet(dbh, set, SaveOptions{})
+		if err == nil {
+			t.Fatalf("Expected an error saving cards without FTS5 to update the index")
+		}
+		t.Skip("SQLite built without FTS5, use -tags sqlite_fts5")
+	}
+	err = dbh.checkFTS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dbh.fts {
+		t.Fatalf("Expected the full text index to be built")
+	}
+	cards, _, err := SearchCards(dbh, []string{"name"}, [][]string{{"goblin"}}, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Name != "Goblin King" {
+		t.Fatalf("Expected cards loaded before the index was built to be found, got %v", cards)
+	}
+	// Migrating again doesn't rerun the index migration
+	err = dbh.Migrate(SchemaMigrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Deck is a saved deck list.  Version and Updated describe its latest
+// version.
+type Deck struct {
+	ID      int64     `json:"id"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Version int       `json:"version"`
+	Updated time.Time `json:"updated"`
+}
+
+// DeckVersion is a deck as it was when it was saved.  Versions of a deck
+// are numbered from 1.
+type DeckVersion struct {
+	ID      int64      `json:"-"`
+	DeckID  int64      `json:"deck_id" db:"deck_id"`
+	Version int        `json:"version"`
+	Note    string     `json:"note"`
+	Created time.Time  `json:"created"`
+	Cards   []DeckCard `json:"-" db:"-"`
+}
+
+// DeckCard is the number of copies of a card in one section of a
+// DeckVersion.  The name is kept so the card can still be found if its
+// printing is removed from the db.
+type DeckCard struct {
+	Section   string `json:"section"`
+	MTGJsonID string `json:"mtg_json_id" db:"mtg_json_id"`
+	Name      string `json:"name"`
+	Count     int    `json:"count"`
+}
+
+const deckSelect = `SELECT deck.id, deck.name, deck.created, v.version, v.created AS updated
+FROM deck JOIN deck_version v ON v.deck_id = deck.id
+  AND v.version = (SELECT MAX(version) FROM deck_version WHERE deck_id = deck.id) `
+
+// SaveDeck saves cards as a new version of the named deck, creating the deck
+// if there isn't one with that name.
+func SaveDeck(dbh *Handle, name, note string, cards []DeckCard) (*DeckVersion, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("Deck name can't be empty")
+	}
+	now := time.Now().UTC()
+	tx, err := dbh.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO deck (name, created) VALUES (?, ?)`, name, now)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	v := &DeckVersion{Note: note, Created: now, Cards: cards}
+	err = tx.Get(&v.DeckID, `SELECT id FROM deck WHERE name = ?`, name)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = tx.Get(&v.Version, `SELECT COALESCE(MAX(version), 0) + 1 FROM deck_version WHERE deck_id = ?`, v.DeckID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	res, err := tx.Exec(`INSERT INTO deck_version (deck_id, version, note, created) VALUES (?, ?, ?, ?)`,
+		v.DeckID, v.Version, v.Note, v.Created)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	v.ID, err = res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for _, c := range cards {
+		_, err = tx.Exec(`INSERT INTO deck_card (version_id, section, mtg_json_id, name, count) VALUES (?, ?, ?, ?, ?)`,
+			v.ID, c.Section, c.MTGJsonID, c.Name, c.Count)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return v, tx.Commit()
+}
+
+// Decks returns all saved decks ordered by name
+func Decks(dbh *Handle) ([]Deck, error) {
+	decks := []Deck{}
+	err := dbh.db.Select(&decks, deckSelect+"ORDER BY deck.name")
+	return decks, err
+}
+
+// DeckByID returns the saved deck with the given id
+func DeckByID(dbh *Handle, id int64) (*Deck, error) {
+	deck := Deck{}
+	err := dbh.db.Get(&deck, deckSelect+"WHERE deck.id = ?", id)
+	return &deck, err
+}
+
+// DeckByName returns the saved deck with the given name
+func DeckByName(dbh *Handle, name string) (*Deck, error) {
+	deck := Deck{}
+	err := dbh.db.Get(&deck, deckSelect+"WHERE deck.name = ?", strings.TrimSpace(name))
+	return &deck, err
+}
+
+// DeckVersions returns the versions of a deck, newest first, without their
+// cards.
+func DeckVersions(dbh *Handle, deckID int64) ([]DeckVersion, error) {
+	versions := []DeckVersion{}
+	err := dbh.db.Select(&versions, `SELECT id, deck_id, version, note, created FROM deck_version
+WHERE deck_id = ? ORDER BY version DESC`, deckID)
+	return versions, err
+}
+
+// GetDeckVersion returns a version of a deck with its cards.  Version 0 is
+// the latest version.
+func GetDeckVersion(dbh *Handle, deckID int64, version int) (*DeckVersion, error) {
+	v := DeckVersion{}
+	var err error
+	if version == 0 {
+		err = dbh.db.Get(&v, `SELECT id, deck_id, version, note, created FROM deck_version
+WHERE deck_id = ? ORDER BY version DESC LIMIT 1`, deckID)
+	} else {
+		err = dbh.db.Get(&v, `SELECT id, deck_id, version, note, created FROM deck_version
+WHERE deck_id = ? AND version = ?`, deckID, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.Cards = []DeckCard{}
+	err = dbh.db.Select(&v.Cards, `SELECT section, mtg_json_id, name, count FROM deck_card
+WHERE version_id = ? ORDER BY id`, v.ID)
+	return &v, err
+}
+
+// RestoreDeckVersion saves the cards of an old version of a deck as its
+// newest version, keeping the versions in between.
+func RestoreDeckVersion(dbh *Handle, deckID int64, version int) (*DeckVersion, error) {
+	deck, err := DeckByID(dbh, deckID)
+	if err != nil {
+		return nil, err
+	}
+	old, err := GetDeckVersion(dbh, deckID, version)
+	if err != nil {
+		return nil, err
+	}
+	return SaveDeck(dbh, deck.Name, fmt.Sprintf("Restored version %d", version), old.Cards)
+}
+
+// DeleteDeck removes a deck and all of its versions
+func DeleteDeck(dbh *Handle, id int64) error {
+	tx, err := dbh.db.Beginx()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM deck_card WHERE version_id IN (SELECT id FROM deck_version WHERE deck_id = ?)", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM deck_version WHERE deck_id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM deck WHERE id = ?", id)
+	if err == nil {
+		err = requireRow(res)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestDeckVersions(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	first := []DeckCard{
+		{Section: "main", MTGJsonID: "1", Name: "Lightning Bolt", Count: 4},
+		{Section: "main", MTGJsonID: "2", Name: "Mountain", Count: 20},
+	}
+	second := []DeckCard{
+		{Section: "main", MTGJsonID: "1", Name: "Lightning Bolt", Count: 4},
+		{Section: "sideboard", MTGJsonID: "3", Name: "Smash to Smithereens", Count: 2},
+	}
+
+	v, err := SaveDeck(dbh, "Burn", "", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != 1 {
+		t.Fatalf("Expected version 1 got %d", v.Version)
+	}
+	v, err = SaveDeck(dbh, " Burn ", "Sideboard tech", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != 2 {
+		t.Fatalf("Expected saving the same name to make version 2 got %d", v.Version)
+	}
+
+	decks, err := Decks(dbh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decks) != 1 || decks[0].Name != "Burn" || decks[0].Version != 2 || decks[0].Updated.IsZero() {
+		t.Fatalf("Expected one deck at version 2 got %+v", decks)
+	}
+
+	latest, err := GetDeckVersion(dbh, decks[0].ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Version != 2 || latest.Note != "Sideboard tech" || !reflect.DeepEqual(latest.Cards, second) {
+		t.Fatalf("Expected latest version to be version 2 got %+v", latest)
+	}
+
+	restored, err := RestoreDeckVersion(dbh, decks[0].ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err = GetDeckVersion(dbh, decks[0].ID, restored.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != 3 || !reflect.DeepEqual(restored.Cards, first) {
+		t.Fatalf("Expected version 1 restored as version 3 got %+v", restored)
+	}
+
+	versions, err := DeckVersions(dbh, decks[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].Version != 3 || versions[0].Note != "Restored version 1" {
+		t.Fatalf("Expected 3 versions newest first got %+v", versions)
+	}
+
+	_, err = GetDeckVersion(dbh, decks[0].ID, 4)
+	if err != sql.ErrNoRows {
+		t.Fatalf("Expected sql.ErrNoRows for a missing version got %v", err)
+	}
+
+	err = DeleteDeck(dbh, decks[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DeckByName(dbh, "Burn")
+	if err != sql.ErrNoRows {
+		t.Fatalf("Expected deleted deck to be gone got %v", err)
+	}
+}
@@ -0,0 +1,429 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+var (
+	// cardColumns lists the card table columns in the order returned by
+	// cardValues.
+	cardColumns = []string{
+		"mtg_json_id",
+		"set_code",
+		"set_name",
+		"release_date",
+		"layout",
+		"power",
+		"toughness",
+		"loyalty",
+		"hand",
+		"life",
+		"cmc",
+		"mana_cost",
+		"name",
+		"names",
+		"search_name",
+		"type",
+		"super_types",
+		"types",
+		"sub_types",
+		"colors",
+		"rarity",
+		"text",
+		"timeshifted",
+		"reserved",
+		"starter",
+		"flavor",
+		"multiverse_id",
+		"number",
+		"source",
+		"watermark",
+		"artist",
+		"image_name",
+		"color_identity",
+	}
+
+	cardUpsert = buildCardUpsert()
+
+	setUpsert = `INSERT INTO "set" (
+"code",
+"name",
+"release_date",
+"border",
+"type",
+"block",
+"gatherer_code",
+"old_code",
+"magic_cards_info_code",
+"online_only") VALUES (:code, :name, :release_date, :border, :type, :block, :gatherer_code, :old_code, :magic_cards_info_code, :online_only)
+ON CONFLICT (code) DO UPDATE SET
+"name" = excluded."name",
+"release_date" = excluded."release_date",
+"border" = excluded."border",
+"type" = excluded."type",
+"block" = excluded."block",
+"gatherer_code" = excluded."gatherer_code",
+"old_code" = excluded."old_code",
+"magic_cards_info_code" = excluded."magic_cards_info_code",
+"online_only" = excluded."online_only"`
+)
+
+func buildCardUpsert() string {
+	quoted := make([]string, len(cardColumns))
+	placeholders := make([]string, len(cardColumns))
+	updates := []string{}
+	for i, col := range cardColumns {
+		quoted[i] = `"` + col + `"`
+		placeholders[i] = "?"
+		if col != "mtg_json_id" {
+			updates = append(updates, fmt.Sprintf(`"%s" = excluded."%s"`, col, col))
+		}
+	}
+	return "INSERT INTO card (" + strings.Join(quoted, ",") + ") VALUES (" + strings.Join(placeholders, ",") +
+		") ON CONFLICT (mtg_json_id) DO UPDATE SET " + strings.Join(updates, ", ")
+}
+
+func cardValues(card *mtgjson.Card) []interface{} {
+	return []interface{}{
+		card.MTGJsonID,
+		card.SetCode,
+		card.SetName,
+		card.ReleaseDate,
+		card.Layout,
+		card.Power,
+		card.Toughness,
+		card.Loyalty,
+		card.Hand,
+		card.Life,
+		card.CMC,
+		card.ManaCost,
+		card.Name,
+		card.Names,
+		normalizeName(card.Name),
+		card.Type,
+		card.Supertypes,
+		card.Types,
+		card.Subtypes,
+		card.Colors,
+		card.Rarity,
+		card.Text,
+		card.Timeshifted,
+		card.Reserved,
+		card.Starter,
+		card.Flavor,
+		card.MultiverseID,
+		card.Number,
+		card.Source,
+		card.Watermark,
+		card.Artist,
+		card.ImageName,
+		card.ColorIdentity,
+	}
+}
+
+func legalityKey(card *mtgjson.Card) string {
+	l := make([]string, len(card.Legalities))
+	for i, leg := range card.Legalities {
+		l[i] = leg.Format + "=" + leg.Legality
+	}
+	sort.Strings(l)
+	return strings.Join(l, ",")
+}
+
+// cardChanged compares the stored values of two cards, including their
+// legalities.
+func cardChanged(stored, incoming *mtgjson.Card) bool {
+	ov, nv := cardValues(stored), cardValues(incoming)
+	for i := range ov {
+		a, b := ov[i], nv[i]
+		if v, ok := a.(driver.Valuer); ok {
+			a, _ = v.Value()
+		}
+		if v, ok := b.(driver.Valuer); ok {
+			b, _ = v.Value()
+		}
+		if fmt.Sprint(a) != fmt.Sprint(b) {
+			return true
+		}
+	}
+	return legalityKey(stored) != legalityKey(incoming)
+}
+
+// SaveOptions controls how sets are written to the db
+type SaveOptions struct {
+	// Prune deletes cards from the db that are no longer in the set
+	Prune bool
+	// ContinueOnError skips cards that fail to save instead of rolling back
+	// the whole set.  Skipped cards are listed in SetSummary.Skipped.
+	ContinueOnError bool
+}
+
+// LoadError is returned when a card can't be saved
+type LoadError struct {
+	Set       string
+	Card      string
+	MTGJsonID string
+	Err       error
+}
+
+func (e *LoadError) Error() string {
+	if e.Card == "" {
+		return fmt.Sprintf("set %s: %s", e.Set, e.Err)
+	}
+	return fmt.Sprintf("set %s, card '%s' (%s): %s", e.Set, e.Card, e.MTGJsonID, e.Err)
+}
+
+// SetSummary reports what changed when saving a set
+type SetSummary struct {
+	Code      string
+	Added     int
+	Updated   int
+	Unchanged int
+	Removed   int
+	Skipped   []*LoadError
+}
+
+func (s SetSummary) String() string {
+	str := fmt.Sprintf("%s: %d added, %d updated, %d removed, %d unchanged", s.Code, s.Added, s.Updated, s.Removed, s.Unchanged)
+	if len(s.Skipped) > 0 {
+		str += fmt.Sprintf(", %d skipped", len(s.Skipped))
+	}
+	return str
+}
+
+// SaveCards saves all given cards to the db
+func SaveCards(db *Handle, sets map[string]mtgjson.Set) error {
+	for _, set := range sets {
+		_, err := SaveSet(db, set, SaveOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execer is the subset of sqlx.Tx used when saving cards
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// saveCard writes card and its legalities, updating the full text index if
+// fts is set.
+func saveCard(tx execer, card *mtgjson.Card, fts bool) error {
+	_, err := tx.Exec(cardUpsert, cardValues(card)...)
+	if err != nil {
+		return err
+	}
+	if fts {
+		_, err = tx.Exec(`DELETE FROM card_fts WHERE rowid = (SELECT id FROM card WHERE mtg_json_id = ?)`, card.MTGJsonID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO card_fts (rowid, name, text, flavor) SELECT id, name, text, flavor FROM card WHERE mtg_json_id = ?`, card.MTGJsonID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`DELETE FROM legality WHERE mtg_json_id = ?`, card.MTGJsonID)
+	if err != nil {
+		return err
+	}
+	for _, l := range card.Legalities {
+		_, err = tx.Exec(`INSERT INTO legality ("mtg_json_id", "format", "legality") VALUES (?,?,?)`, card.MTGJsonID, l.Format, l.Legality)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveRulings(tx execer, card *mtgjson.Card) error {
+	for _, r := range card.Rulings {
+		// Reprints share rulings so only the first copy is kept
+		_, err := tx.Exec(`INSERT OR IGNORE INTO ruling ("search_name", "date", "text") VALUES (?,?,?)`, normalizeName(card.Name), r.Date, r.Text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveSet saves the cards of a single set to the db in one transaction.  Cards
+// already in the db are updated in place if they have changed so loading a
+// newer dump over an existing db is safe.  If a card fails to save the
+// transaction is rolled back and a *LoadError is returned, unless
+// opts.ContinueOnError is set.
+func SaveSet(db *Handle, set mtgjson.Set, opts SaveOptions) (*SetSummary, error) {
+	summary := &SetSummary{Code: set.Code}
+	err := db.checkFTSWritable()
+	if err != nil {
+		return nil, &LoadError{Set: set.Code, Err: err}
+	}
+
+	existing := []mtgjson.Card{}
+	err = db.db.Select(&existing, "SELECT * FROM card WHERE set_code = ?", set.Code)
+	if err != nil {
+		return nil, &LoadError{Set: set.Code, Err: err}
+	}
+	err = AddLegalities(db, CardPointers(existing)...)
+	if err != nil {
+		return nil, &LoadError{Set: set.Code, Err: err}
+	}
+	existingByID := make(map[string]*mtgjson.Card, len(existing))
+	for i := range existing {
+		existingByID[existing[i].MTGJsonID] = &existing[i]
+	}
+
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, &LoadError{Set: set.Code, Err: err}
+	}
+	_, err = tx.NamedExec(setUpsert, set)
+	if err != nil {
+		tx.Rollback()
+		return nil, &LoadError{Set: set.Code, Err: err}
+	}
+	logrus.Infof("Saving %d cards from %s: %s", len(set.Cards), set.Code, set.Name)
+	seen := make(map[string]bool, len(set.Cards))
+	for _, card := range set.Cards {
+		seen[card.MTGJsonID] = true
+		old, ok := existingByID[card.MTGJsonID]
+		changed := !ok || cardChanged(old, card)
+
+		// A savepoint lets a single bad card be undone without losing the
+		// rest of the set.
+		_, err = tx.Exec("SAVEPOINT card")
+		if err == nil {
+			err = saveRulings(tx, card)
+		}
+		if err == nil && changed {
+			err = saveCard(tx, card, db.fts)
+		}
+		if err != nil {
+			lerr := &LoadError{Set: set.Code, Card: card.Name, MTGJsonID: card.MTGJsonID, Err: err}
+			if !opts.ContinueOnError {
+				tx.Rollback()
+				return nil, lerr
+			}
+			_, err = tx.Exec("ROLLBACK TO card")
+			if err != nil {
+				tx.Rollback()
+				return nil, &LoadError{Set: set.Code, Err: err}
+			}
+			logrus.Warnf("Skipping card: %s", lerr)
+			summary.Skipped = append(summary.Skipped, lerr)
+			continue
+		}
+		_, err = tx.Exec("RELEASE card")
+		if err != nil {
+			tx.Rollback()
+			return nil, &LoadError{Set: set.Code, Err: err}
+		}
+
+		switch {
+		case !changed:
+			summary.Unchanged++
+		case ok:
+			summary.Updated++
+		default:
+			summary.Added++
+		}
+	}
+
+	if opts.Prune {
+		for id := range existingByID {
+			if seen[id] {
+				continue
+			}
+			if db.fts {
+				_, err = tx.Exec(`DELETE FROM card_fts WHERE rowid = (SELECT id FROM card WHERE mtg_json_id = ?)`, id)
+			}
+			if err == nil {
+				_, err = tx.Exec(`DELETE FROM card WHERE mtg_json_id = ?`, id)
+			}
+			if err == nil {
+				_, err = tx.Exec(`DELETE FROM legality WHERE mtg_json_id = ?`, id)
+			}
+			if err != nil {
+				tx.Rollback()
+				return nil, &LoadError{Set: set.Code, Card: existingByID[id].Name, MTGJsonID: id, Err: err}
+			}
+			summary.Removed++
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, &LoadError{Set: set.Code, Err: err}
+	}
+	db.resetNames()
+	return summary, nil
+}
+
+// PruneSets deletes all cards whose set isn't in keep, returning a summary
+// for each set removed.
+func PruneSets(db *Handle, keep []string) ([]*SetSummary, error) {
+	err := db.checkFTSWritable()
+	if err != nil {
+		return nil, err
+	}
+	counts := []struct {
+		Code  string `db:"set_code"`
+		Count int    `db:"count"`
+	}{}
+	err = db.db.Select(&counts, "SELECT set_code, count(*) AS count FROM card GROUP BY set_code")
+	if err != nil {
+		return nil, err
+	}
+	keepSet := make(map[string]bool, len(keep))
+	for _, code := range keep {
+		keepSet[code] = true
+	}
+
+	summaries := []*SetSummary{}
+	tx, err := db.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		if keepSet[c.Code] {
+			continue
+		}
+		_, err = tx.Exec(`DELETE FROM legality WHERE mtg_json_id IN (SELECT mtg_json_id FROM card WHERE set_code = ?)`, c.Code)
+		if err == nil && db.fts {
+			_, err = tx.Exec(`DELETE FROM card_fts WHERE rowid IN (SELECT id FROM card WHERE set_code = ?)`, c.Code)
+		}
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM card WHERE set_code = ?`, c.Code)
+		}
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM "set" WHERE code = ?`, c.Code)
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, &LoadError{Set: c.Code, Err: err}
+		}
+		summaries = append(summaries, &SetSummary{Code: c.Code, Removed: c.Count})
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	db.resetNames()
+	return summaries, nil
+}
+
+// CardPointers returns pointers into cards, e.g. for AddLegalities
+func CardPointers(cards []mtgjson.Card) []*mtgjson.Card {
+	ptrs := make([]*mtgjson.Card, len(cards))
+	for i := range cards {
+		ptrs[i] = &cards[i]
+	}
+	return ptrs
+}
@@ -0,0 +1,284 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+// diacritics maps the accented letters used in card names to plain ASCII
+var diacritics = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ä': "a", 'ã': "a", 'å': "a",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ò': "o", 'ó': "o", 'ô': "o", 'ö': "o", 'õ': "o", 'ø': "o",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u",
+	'ñ': "n", 'ç': "c", 'ý': "y", 'ÿ': "y",
+	'æ': "ae", 'œ': "oe", 'ß': "ss",
+}
+
+// FoldName lowercases name and replaces accented letters so "Lim-Dûl" and
+// "lim-dul" compare equal.
+func FoldName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if s, ok := diacritics[r]; ok {
+			b.WriteString(s)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// matchName folds name and reduces punctuation to single spaces so "Urza’s
+// Tower" matches "urza's tower ".
+func matchName(name string) string {
+	return strings.Join(strings.FieldsFunc(FoldName(name), func(r rune) bool {
+		return !isNameLetter(r)
+	}), " ")
+}
+
+// nameKey is a folded string pointing back to the name it came from
+type nameKey struct {
+	key  string
+	name int
+}
+
+// NameIndex answers name autocomplete queries from memory.  Names are kept
+// sorted by their folded form, along with the start of every later word in
+// each name, so prefix and word start lookups are binary searches.
+type NameIndex struct {
+	names  []string
+	folded []string
+	words  []nameKey
+	// matches maps matchName keys to indexes of names
+	matches map[string]int
+}
+
+// CardNames returns the distinct card names in the db
+func CardNames(dbh *Handle) ([]string, error) {
+	names := []string{}
+	err := dbh.db.Select(&names, "SELECT name FROM card GROUP BY search_name ORDER BY search_name")
+	return names, err
+}
+
+// NewNameIndex builds a NameIndex from the names in the db
+func NewNameIndex(dbh *Handle) (*NameIndex, error) {
+	names, err := CardNames(dbh)
+	if err != nil {
+		return nil, err
+	}
+	return newNameIndex(names), nil
+}
+
+func newNameIndex(names []string) *NameIndex {
+	keys := make([]nameKey, len(names))
+	for i, name := range names {
+		keys[i] = nameKey{key: FoldName(name), name: i}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key < keys[j].key })
+
+	idx := &NameIndex{
+		names:   make([]string, len(names)),
+		folded:  make([]string, len(names)),
+		matches: make(map[string]int, len(names)),
+	}
+	for i, k := range keys {
+		idx.names[i] = names[k.name]
+		idx.folded[i] = k.key
+		if _, ok := idx.matches[matchName(k.key)]; !ok {
+			idx.matches[matchName(k.key)] = i
+		}
+		prevLetter := true
+		for j, r := range k.key {
+			letter := isNameLetter(r)
+			if letter && !prevLetter {
+				idx.words = append(idx.words, nameKey{key: k.key[j:], name: i})
+			}
+			prevLetter = letter
+		}
+	}
+	sort.Slice(idx.words, func(i, j int) bool { return idx.words[i].key < idx.words[j].key })
+	return idx
+}
+
+func isNameLetter(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// Len returns the number of names in the index
+func (idx *NameIndex) Len() int {
+	return len(idx.names)
+}
+
+// Complete returns up to limit names containing q, ignoring case and
+// diacritics.  Names starting with q come first, then names with a word
+// starting with q and finally names containing q anywhere.
+func (idx *NameIndex) Complete(q string, limit int) []string {
+	q = FoldName(strings.TrimSpace(q))
+	res := []string{}
+	if q == "" || limit < 1 {
+		return res
+	}
+	seen := map[int]bool{}
+	add := func(i int) bool {
+		if !seen[i] {
+			seen[i] = true
+			res = append(res, idx.names[i])
+		}
+		return len(res) >= limit
+	}
+
+	start := sort.SearchStrings(idx.folded, q)
+	for i := start; i < len(idx.folded) && strings.HasPrefix(idx.folded[i], q); i++ {
+		if add(i) {
+			return res
+		}
+	}
+
+	// Word matches are collected first so they can be ordered by name
+	wordStart := sort.Search(len(idx.words), func(i int) bool { return idx.words[i].key >= q })
+	matches := []int{}
+	for i := wordStart; i < len(idx.words) && strings.HasPrefix(idx.words[i].key, q); i++ {
+		matches = append(matches, idx.words[i].name)
+	}
+	sort.Ints(matches)
+	for _, i := range matches {
+		if add(i) {
+			return res
+		}
+	}
+
+	for i, f := range idx.folded {
+		if strings.Contains(f, q) && add(i) {
+			return res
+		}
+	}
+	return res
+}
+
+// Lookup returns the name in the index matching name, ignoring case,
+// diacritics and punctuation.  Split and double faced cards can be given as
+// either face or both faces separated by "/" or "//".
+func (idx *NameIndex) Lookup(name string) (string, bool) {
+	if i, ok := idx.matches[matchName(name)]; ok {
+		return idx.names[i], true
+	}
+	if strings.Contains(name, "/") {
+		for _, face := range strings.Split(name, "/") {
+			if i, ok := idx.matches[matchName(face)]; ok {
+				return idx.names[i], true
+			}
+		}
+	}
+	return "", false
+}
+
+// Suggest returns up to limit names a few typos away from name, closest
+// first.
+func (idx *NameIndex) Suggest(name string, limit int) []string {
+	key := []rune(matchName(name))
+	maxDist := len(key) / 4
+	if maxDist < 1 {
+		maxDist = 1
+	} else if maxDist > 3 {
+		maxDist = 3
+	}
+
+	type suggestion struct {
+		name string
+		dist int
+	}
+	found := []suggestion{}
+	for k, i := range idx.matches {
+		other := []rune(k)
+		if diff := len(other) - len(key); diff > maxDist || -diff > maxDist {
+			continue
+		}
+		if d := editDistance(key, other); d <= maxDist {
+			found = append(found, suggestion{idx.names[i], d})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].dist != found[j].dist {
+			return found[i].dist < found[j].dist
+		}
+		return found[i].name < found[j].name
+	})
+	res := []string{}
+	for i := 0; i < len(found) && i < limit; i++ {
+		res = append(res, found[i].name)
+	}
+	return res
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// UnknownCardError is returned by ResolveCard for names that don't match a
+// card, with any close matches.
+type UnknownCardError struct {
+	Name        string
+	Suggestions []string
+}
+
+func (e *UnknownCardError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("Unknown card: '%s'", e.Name)
+	}
+	quoted := make([]string, len(e.Suggestions))
+	for i, s := range e.Suggestions {
+		quoted[i] = "'" + s + "'"
+	}
+	return fmt.Sprintf("Unknown card: '%s', did you mean %s?", e.Name, strings.Join(quoted, " or "))
+}
+
+// ResolveCard is like CardByName but forgiving of how the name is written,
+// see NameIndex.Lookup.  Unmatched names return an *UnknownCardError.
+func ResolveCard(dbh *Handle, name string) (*mtgjson.Card, error) {
+	card, err := CardByName(dbh, name)
+	if err != sql.ErrNoRows {
+		return card, err
+	}
+	names, err := dbh.Names()
+	if err != nil {
+		return nil, err
+	}
+	if found, ok := names.Lookup(name); ok {
+		return CardByName(dbh, found)
+	}
+	return nil, &UnknownCardError{Name: strings.TrimSpace(name), Suggestions: names.Suggest(name, 3)}
+}
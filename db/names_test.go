@@ -0,0 +1,109 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+func TestNameIndexComplete(t *testing.T) {
+	idx := newNameIndex([]string{
+		"Lim-Dûl the Necromancer",
+		"Select for Inspection",
+		"Selesnya Charm",
+		"Chosen by Heliod",
+		"Reselect",
+		"Jace, the Mind Sculptor",
+		"Æther Vial",
+	})
+
+	tests := []struct {
+		q        string
+		limit    int
+		expected []string
+	}{
+		{"sel", 10, []string{"Select for Inspection", "Selesnya Charm", "Reselect"}},
+		{"SEL", 1, []string{"Select for Inspection"}},
+		{"lim-dul", 10, []string{"Lim-Dûl the Necromancer"}},
+		{"dul", 10, []string{"Lim-Dûl the Necromancer"}},
+		{"mind sc", 10, []string{"Jace, the Mind Sculptor"}},
+		{"the", 10, []string{"Jace, the Mind Sculptor", "Lim-Dûl the Necromancer", "Æther Vial"}},
+		{"aether", 10, []string{"Æther Vial"}},
+		{"hel", 10, []string{"Chosen by Heliod"}},
+		{"", 10, []string{}},
+		{"xyz", 10, []string{}},
+	}
+	for _, test := range tests {
+		res := idx.Complete(test.q, test.limit)
+		if !reflect.DeepEqual(res, test.expected) {
+			t.Errorf("Expected '%s' to give %v got %v", test.q, test.expected, res)
+		}
+	}
+}
+
+func TestNameIndexLookup(t *testing.T) {
+	idx := newNameIndex([]string{"Jötun Grunt", "Urza's Tower", "Fire", "Ice", "Lightning Bolt"})
+	tests := map[string]string{
+		"Lightning Bolt ": "Lightning Bolt",
+		"jotun grunt":     "Jötun Grunt",
+		"Urza’s Tower":    "Urza's Tower",
+		"Fire // Ice":     "Fire",
+		"Fire/Ice":        "Fire",
+		"Lightnig Bolt":   "",
+	}
+	for name, expected := range tests {
+		found, ok := idx.Lookup(name)
+		if found != expected || ok != (expected != "") {
+			t.Errorf("Expected '%s' to find '%s' got '%s'", name, expected, found)
+		}
+	}
+
+	suggestions := idx.Suggest("Lightnig Bolt", 3)
+	if !reflect.DeepEqual(suggestions, []string{"Lightning Bolt"}) {
+		t.Errorf("Expected a suggestion of Lightning Bolt got %v", suggestions)
+	}
+	if suggestions := idx.Suggest("Counterspell", 3); len(suggestions) != 0 {
+		t.Errorf("Expected no suggestions got %v", suggestions)
+	}
+}
+
+func TestResolveCard(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	set := mtgjson.Set{
+		Code:  "TST",
+		Cards: []*mtgjson.Card{{MTGJsonID: "1", SetCode: "TST", Name: "Jötun Grunt"}},
+	}
+	_, err := SaveSet(dbh, set, SaveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := ResolveCard(dbh, "Jotun Grunt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Name != "Jötun Grunt" {
+		t.Fatalf("Expected Jötun Grunt got %s", card.Name)
+	}
+
+	_, err = ResolveCard(dbh, "Jotun Grunts")
+	expected := "Unknown card: 'Jotun Grunts', did you mean 'Jötun Grunt'?"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Expected error '%s' got %v", expected, err)
+	}
+
+	// The name index is rebuilt after loading more cards
+	set.Cards = append(set.Cards, &mtgjson.Card{MTGJsonID: "2", SetCode: "TST", Name: "Æther Vial"})
+	_, err = SaveSet(dbh, set, SaveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err = ResolveCard(dbh, "aether vial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Name != "Æther Vial" {
+		t.Fatalf("Expected Æther Vial got %s", card.Name)
+	}
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/hobeone/mtgbrew/mtgjson"
+	"github.com/jmoiron/sqlx"
+)
+
+// FinishNormal is the finish of non-foil cards in price data
+const FinishNormal = "normal"
+
+// CardPrice is a price along with the printing it is for
+type CardPrice struct {
+	mtgjson.Price
+	Name    string `json:"name"`
+	SetCode string `json:"set" db:"set_code"`
+	Number  string `json:"number"`
+}
+
+// SavePrices adds prices to the price history, replacing any already saved
+// for the same printing, vendor, finish and date.  The price table keeps
+// only the most recent price of each printing, vendor and finish.
+func SavePrices(dbh *Handle, prices []mtgjson.Price) error {
+	tx, err := dbh.db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, p := range prices {
+		_, err = tx.Exec(`INSERT INTO price_history (mtg_json_id, vendor, finish, date, price, currency) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (mtg_json_id, vendor, finish, date) DO UPDATE SET price = excluded.price, currency = excluded.currency`,
+			p.MTGJsonID, p.Vendor, p.Finish, p.Date, p.Price, p.Currency)
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM price WHERE mtg_json_id = ? AND vendor = ? AND finish = ? AND date < ?`,
+				p.MTGJsonID, p.Vendor, p.Finish, p.Date)
+		}
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO price (mtg_json_id, vendor, finish, date, price, currency)
+SELECT ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM price WHERE mtg_json_id = ? AND vendor = ? AND finish = ? AND date > ?)
+ON CONFLICT (mtg_json_id, vendor, finish, date) DO UPDATE SET price = excluded.price, currency = excluded.currency`,
+				p.MTGJsonID, p.Vendor, p.Finish, p.Date, p.Price, p.Currency,
+				p.MTGJsonID, p.Vendor, p.Finish, p.Date)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UnknownPriceCards returns how many of the cards prices are for aren't in
+// the card table.  MTGJSON prices are keyed by v5 uuids so none of them
+// match cards loaded from a v3 AllSets file.
+func UnknownPriceCards(dbh *Handle, prices []mtgjson.Price) (int, error) {
+	seen := map[string]bool{}
+	ids := []interface{}{}
+	for _, p := range prices {
+		if !seen[p.MTGJsonID] {
+			seen[p.MTGJsonID] = true
+			ids = append(ids, p.MTGJsonID)
+		}
+	}
+	known := 0
+	// Stay well under SQLite's limit on the number of query parameters
+	chunk := 500
+	for start := 0; start < len(ids); start += chunk {
+		end := start + chunk
+		if end > len(ids) {
+			end = len(ids)
+		}
+		query, args, err := sqlx.In("SELECT count(*) FROM card WHERE mtg_json_id IN (?)", ids[start:end])
+		if err != nil {
+			return 0, err
+		}
+		n := 0
+		err = dbh.db.Get(&n, query, args...)
+		if err != nil {
+			return 0, err
+		}
+		known += n
+	}
+	return len(ids) - known, nil
+}
+
+// CheapestPrice returns the lowest latest price from vendor for any
+// printing of the named card in the given finish.
+func CheapestPrice(dbh *Handle, name, vendor, finish string) (*CardPrice, error) {
+	p := CardPrice{}
+	err := dbh.db.Get(&p, `SELECT p.mtg_json_id, p.vendor, p.finish, p.date, p.price, p.currency, card.name, card.set_code, card.number
+FROM price p JOIN card ON card.mtg_json_id = p.mtg_json_id
+WHERE card.search_name = ? AND p.vendor = ? AND p.finish = ?
+ORDER BY p.price, card.release_date DESC LIMIT 1`, normalizeName(name), vendor, finish)
+	return &p, err
+}
+
+// latestPriceDate returns the date of the most recent prices from vendor in
+// finish, or "" if there are none.  Empty vendor or finish match any.
+func latestPriceDate(dbh *Handle, vendor, finish string) (string, error) {
+	var latest sql.NullString
+	err := dbh.db.Get(&latest, `SELECT MAX(date) FROM price_history WHERE (? = '' OR vendor = ?) AND (? = '' OR finish = ?)`,
+		vendor, vendor, finish, finish)
+	return latest.String, err
+}
+
+// daysBefore returns the date days before date, both as YYYY-MM-DD
+func daysBefore(date string, days int) (string, error) {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return "", err
+	}
+	return t.AddDate(0, 0, -days).Format("2006-01-02"), nil
+}
+
+// PriceHistory returns the saved prices for printings of the named card,
+// ordered by printing, vendor, finish and date.  Empty vendor or finish
+// match any.  If days isn't zero only prices from the days before the most
+// recent prices imported from the vendor are returned, so a series doesn't
+// empty out if imports stop.
+func PriceHistory(dbh *Handle, name, vendor, finish string, days int) ([]CardPrice, error) {
+	prices := []CardPrice{}
+	since := ""
+	if days > 0 {
+		latest, err := latestPriceDate(dbh, vendor, finish)
+		if err != nil || latest == "" {
+			return prices, err
+		}
+		since, err = daysBefore(latest, days)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := dbh.db.Select(&prices, `SELECT p.mtg_json_id, p.vendor, p.finish, p.date, p.price, p.currency, card.name, card.set_code, card.number
+FROM price_history p JOIN card ON card.mtg_json_id = p.mtg_json_id
+WHERE card.search_name = ? AND (? = '' OR p.vendor = ?) AND (? = '' OR p.finish = ?) AND p.date >= ?
+ORDER BY card.release_date, card.set_code, card.number, p.vendor, p.finish, p.date`,
+		normalizeName(name), vendor, vendor, finish, finish, since)
+	return prices, err
+}
+
+// PriceMove is the change in a printing's price between two dates
+type PriceMove struct {
+	CardPrice
+	OldDate  string  `json:"old_date" db:"old_date"`
+	OldPrice float64 `json:"old_price" db:"old_price"`
+}
+
+// Change returns how much the price went up, negative if it went down
+func (m PriceMove) Change() float64 {
+	return m.Price.Price - m.OldPrice
+}
+
+// Percent returns the change as a percentage of the old price
+func (m PriceMove) Percent() float64 {
+	if m.OldPrice == 0 {
+		return 0
+	}
+	return m.Change() / m.OldPrice * 100
+}
+
+// PriceMovers compares the latest prices from vendor in the given finish
+// to each printing's last price at least days before them.  If
+// collectionID isn't zero only printings in that collection are compared.
+// Printings without an old enough price are left out.
+func PriceMovers(dbh *Handle, vendor, finish string, days int, collectionID int64) ([]PriceMove, error) {
+	moves := []PriceMove{}
+	latest, err := latestPriceDate(dbh, vendor, finish)
+	if err != nil || latest == "" {
+		return moves, err
+	}
+	cutoff, err := daysBefore(latest, days)
+	if err != nil {
+		return nil, err
+	}
+	err = dbh.db.Select(&moves, `SELECT p.mtg_json_id, p.vendor, p.finish, p.date, p.price, p.currency, card.name, card.set_code, card.number,
+  old.date AS old_date, old.price AS old_price
+FROM price_history p
+JOIN card ON card.mtg_json_id = p.mtg_json_id
+JOIN price_history old ON old.mtg_json_id = p.mtg_json_id AND old.vendor = p.vendor AND old.finish = p.finish
+  AND old.date = (SELECT MAX(date) FROM price_history WHERE mtg_json_id = p.mtg_json_id AND vendor = p.vendor AND finish = p.finish AND date <= ?)
+WHERE p.vendor = ? AND p.finish = ? AND p.date = ?
+  AND (? = 0 OR p.mtg_json_id IN (SELECT mtg_json_id FROM collection_card WHERE collection_id = ?))
+ORDER BY card.name, card.set_code, card.number`,
+		cutoff, vendor, finish, latest, collectionID, collectionID)
+	return moves, err
+}
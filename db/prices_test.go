@@ -0,0 +1,169 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+func TestCheapestPrice(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	sets := map[string]mtgjson.Set{
+		"LEA": {Code: "LEA", Cards: []*mtgjson.Card{{MTGJsonID: "1", SetCode: "LEA", Name: "Shivan Dragon", Number: "174"}}},
+		"M10": {Code: "M10", Cards: []*mtgjson.Card{{MTGJsonID: "2", SetCode: "M10", Name: "Shivan Dragon", Number: "158"}}},
+	}
+	err := SaveCards(dbh, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SavePrices(dbh, []mtgjson.Price{
+		{MTGJsonID: "1", Vendor: "tcgplayer", Finish: "normal", Date: "2024-01-01", Price: 500, Currency: "USD"},
+		// Old prices of a printing don't count
+		{MTGJsonID: "2", Vendor: "tcgplayer", Finish: "normal", Date: "2024-01-01", Price: 0.10, Currency: "USD"},
+		{MTGJsonID: "2", Vendor: "tcgplayer", Finish: "normal", Date: "2024-01-02", Price: 0.50, Currency: "USD"},
+		{MTGJsonID: "2", Vendor: "tcgplayer", Finish: "foil", Date: "2024-01-02", Price: 3, Currency: "USD"},
+		{MTGJsonID: "2", Vendor: "cardmarket", Finish: "normal", Date: "2024-01-02", Price: 0.20, Currency: "EUR"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Saving the same key again replaces the price
+	err = SavePrices(dbh, []mtgjson.Price{
+		{MTGJsonID: "2", Vendor: "tcgplayer", Finish: "normal", Date: "2024-01-02", Price: 0.45, Currency: "USD"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := CheapestPrice(dbh, "shivan dragon", "tcgplayer", FinishNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SetCode != "M10" || p.Price.Price != 0.45 || p.Date != "2024-01-02" || p.Currency != "USD" {
+		t.Fatalf("Expected latest M10 price got %+v", p)
+	}
+
+	_, err = CheapestPrice(dbh, "Shivan Dragon", "scg", FinishNormal)
+	if err != sql.ErrNoRows {
+		t.Fatalf("Expected sql.ErrNoRows for a vendor without prices got %v", err)
+	}
+}
+
+func TestUnknownPriceCards(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	err := SaveCards(dbh, map[string]mtgjson.Set{
+		"LEA": {Code: "LEA", Cards: []*mtgjson.Card{{MTGJsonID: "1", SetCode: "LEA", Name: "Shivan Dragon", Number: "174"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := UnknownPriceCards(dbh, []mtgjson.Price{
+		{MTGJsonID: "1", Vendor: "tcgplayer", Finish: "normal"},
+		{MTGJsonID: "1", Vendor: "tcgplayer", Finish: "foil"},
+		{MTGJsonID: "v5-uuid", Vendor: "tcgplayer", Finish: "normal"},
+		{MTGJsonID: "v5-uuid", Vendor: "cardmarket", Finish: "normal"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected 1 unknown card got %d", n)
+	}
+}
+
+func TestPriceHistoryAndMovers(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	sets := map[string]mtgjson.Set{
+		"LEA": {Code: "LEA", Cards: []*mtgjson.Card{
+			{MTGJsonID: "1", SetCode: "LEA", Name: "Shivan Dragon", Number: "174"},
+			{MTGJsonID: "2", SetCode: "LEA", Name: "Lightning Bolt", Number: "161"},
+			{MTGJsonID: "3", SetCode: "LEA", Name: "Giant Growth", Number: "198"},
+		}},
+	}
+	err := SaveCards(dbh, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	price := func(id, date string, amount float64) mtgjson.Price {
+		return mtgjson.Price{MTGJsonID: id, Vendor: "tcgplayer", Finish: FinishNormal, Date: date, Price: amount, Currency: "USD"}
+	}
+	err = SavePrices(dbh, []mtgjson.Price{
+		price("1", "2024-01-01", 400),
+		price("1", "2024-01-05", 420),
+		price("1", "2024-01-08", 500),
+		price("2", "2024-01-01", 300),
+		price("2", "2024-01-08", 240),
+		// Only a week old if an older price exists
+		price("3", "2024-01-04", 1),
+		price("3", "2024-01-08", 2),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An older import doesn't replace the latest price
+	err = SavePrices(dbh, []mtgjson.Price{price("1", "2024-01-02", 410)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := CheapestPrice(dbh, "Shivan Dragon", "tcgplayer", FinishNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Price.Price != 500 || p.Date != "2024-01-08" {
+		t.Fatalf("Expected latest price of 500 got %+v", p)
+	}
+
+	// Days count back from the latest import, not today
+	history, err := PriceHistory(dbh, "shivan dragon", "", "", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[0].Date != "2024-01-02" || history[2].Price.Price != 500 || history[0].SetCode != "LEA" {
+		t.Fatalf("Expected 3 prices since 2024-01-02 got %+v", history)
+	}
+	history, err = PriceHistory(dbh, "shivan dragon", "tcgplayer", FinishNormal, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 4 {
+		t.Fatalf("Expected all 4 prices got %+v", history)
+	}
+
+	moves, err := PriceMovers(dbh, "tcgplayer", FinishNormal, 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 2 {
+		t.Fatalf("Expected moves for the two cards priced a week ago got %+v", moves)
+	}
+	if moves[0].Name != "Lightning Bolt" || moves[0].Change() != -60 || moves[0].Percent() != -20 {
+		t.Fatalf("Expected Lightning Bolt to drop 20%% got %+v", moves[0])
+	}
+	if moves[1].Name != "Shivan Dragon" || moves[1].OldDate != "2024-01-01" || moves[1].Percent() != 25 {
+		t.Fatalf("Expected Shivan Dragon to rise 25%% got %+v", moves[1])
+	}
+
+	coll, err := CreateCollection(dbh, "Binder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddCollectionCards(dbh, coll.ID, []CollectionCard{{MTGJsonID: "2", Quantity: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves, err = PriceMovers(dbh, "tcgplayer", FinishNormal, 7, coll.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 1 || moves[0].Name != "Lightning Bolt" {
+		t.Fatalf("Expected only the collection's Lightning Bolt got %+v", moves)
+	}
+
+	moves, err = PriceMovers(dbh, "scg", FinishNormal, 7, 0)
+	if err != nil || len(moves) != 0 {
+		t.Fatalf("Expected no moves for a vendor without prices got %v %+v", err, moves)
+	}
+}
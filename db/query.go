@@ -0,0 +1,542 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+* A small Scryfall style query language for the card table.
+*
+* Examples:
+*   c:rg t:creature cmc<=3 o:haste -o:sacrifice
+*   (t:instant or t:sorcery) o:"draw a card"
+*   "serra angel" or r:mythic s:lea
+*
+* Terms are ANDed together unless joined with "or".  A leading "-" negates a
+* term or parenthesized group.  Bare words and quoted phrases match against
+* the card name.
+*
+* Colors: c:rg is red or green, c>=rg is at least red and green, c=rg is
+* exactly red and green and c<=rg is at most red and green.
+ */
+
+// Query is a parsed search expression that can be compiled to SQL.
+type Query interface {
+	// SQL returns a WHERE clause fragment and the arguments for its
+	// placeholders.
+	SQL() (string, []interface{})
+}
+
+type andQuery struct {
+	left, right Query
+}
+
+func (q andQuery) SQL() (string, []interface{}) {
+	l, largs := q.left.SQL()
+	r, rargs := q.right.SQL()
+	return "(" + l + " AND " + r + ")", append(largs, rargs...)
+}
+
+type orQuery struct {
+	left, right Query
+}
+
+func (q orQuery) SQL() (string, []interface{}) {
+	l, largs := q.left.SQL()
+	r, rargs := q.right.SQL()
+	return "(" + l + " OR " + r + ")", append(largs, rargs...)
+}
+
+type notQuery struct {
+	q Query
+}
+
+func (q notQuery) SQL() (string, []interface{}) {
+	s, args := q.q.SQL()
+	return "NOT " + s, args
+}
+
+// termQuery is a single compiled condition like "cmc <= ?"
+type termQuery struct {
+	clause string
+	args   []interface{}
+}
+
+func (q termQuery) SQL() (string, []interface{}) {
+	args := make([]interface{}, len(q.args))
+	copy(args, q.args)
+	return "(" + q.clause + ")", args
+}
+
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	exactField
+	numericField
+	colorField
+	rarityField
+	legalityField
+)
+
+type queryField struct {
+	column string
+	kind   fieldKind
+}
+
+var (
+	queryFields = map[string]queryField{
+		"name":       {"name", textField},
+		"n":          {"name", textField},
+		"oracle":     {"text", textField},
+		"o":          {"text", textField},
+		"text":       {"text", textField},
+		"type":       {"type", textField},
+		"t":          {"type", textField},
+		"flavor":     {"flavor", textField},
+		"ft":         {"flavor", textField},
+		"artist":     {"artist", textField},
+		"a":          {"artist", textField},
+		"mana":       {"mana_cost", textField},
+		"m":          {"mana_cost", textField},
+		"set":        {"set_code", exactField},
+		"s":          {"set_code", exactField},
+		"e":          {"set_code", exactField},
+		"layout":     {"layout", exactField},
+		"cmc":        {"cmc", numericField},
+		"mv":         {"cmc", numericField},
+		"power":      {"power", numericField},
+		"pow":        {"power", numericField},
+		"toughness":  {"toughness", numericField},
+		"tou":        {"toughness", numericField},
+		"loyalty":    {"loyalty", numericField},
+		"loy":        {"loyalty", numericField},
+		"color":      {"colors", colorField},
+		"c":          {"colors", colorField},
+		"rarity":     {"rarity", rarityField},
+		"r":          {"rarity", rarityField},
+		"format":     {"'legal', 'restricted'", legalityField},
+		"f":          {"'legal', 'restricted'", legalityField},
+		"legal":      {"'legal', 'restricted'", legalityField},
+		"banned":     {"'banned'", legalityField},
+		"restricted": {"'restricted'", legalityField},
+	}
+
+	// Order matters: two character operators must be checked first.
+	queryOperators = []string{"<=", ">=", "!=", ":", "=", "<", ">"}
+
+	colorNames = []string{"white", "blue", "black", "red", "green"}
+
+	colorAbbrevs = map[rune]string{
+		'w': "white",
+		'u': "blue",
+		'b': "black",
+		'r': "red",
+		'g': "green",
+	}
+
+	rarityNames = map[string]string{
+		"c":        "common",
+		"common":   "common",
+		"u":        "uncommon",
+		"uncommon": "uncommon",
+		"r":        "rare",
+		"rare":     "rare",
+		"m":        "mythic rare",
+		"mythic":   "mythic rare",
+		"s":        "special",
+		"special":  "special",
+		"b":        "basic land",
+		"basic":    "basic land",
+	}
+
+	rarityRank = `CASE lower(rarity) WHEN 'basic land' THEN 0 WHEN 'common' THEN 1 WHEN 'uncommon' THEN 2 WHEN 'rare' THEN 3 WHEN 'mythic rare' THEN 4 WHEN 'special' THEN 5 ELSE 1 END`
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokLParen
+	tokRParen
+	tokNot
+	tokOr
+	tokAnd
+	tokTerm
+)
+
+type token struct {
+	typ   tokenType
+	key   string // empty for bare words and phrases
+	op    string
+	value string
+}
+
+func isTermBreak(r rune) bool {
+	return unicode.IsSpace(r) || r == '(' || r == ')'
+}
+
+func lexQuery(input string) ([]token, error) {
+	runes := []rune(input)
+	tokens := []token{}
+	i := 0
+
+	readQuoted := func() (string, error) {
+		start := i
+		i++ // opening quote
+		for i < len(runes) && runes[i] != '"' {
+			i++
+		}
+		if i >= len(runes) {
+			return "", fmt.Errorf("Unterminated quote starting at position %d", start)
+		}
+		s := string(runes[start+1 : i])
+		i++ // closing quote
+		return s, nil
+	}
+
+	readBare := func() string {
+		start := i
+		for i < len(runes) && !isTermBreak(runes[i]) {
+			i++
+		}
+		return string(runes[start:i])
+	}
+
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{typ: tokLParen})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{typ: tokRParen})
+			i++
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]):
+			tokens = append(tokens, token{typ: tokNot})
+			i++
+		case r == '"':
+			s, err := readQuoted()
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{typ: tokTerm, value: s})
+		default:
+			start := i
+			for i < len(runes) && unicode.IsLetter(runes[i]) {
+				i++
+			}
+			key := strings.ToLower(string(runes[start:i]))
+			op := ""
+			rest := string(runes[i:])
+			for _, o := range queryOperators {
+				if strings.HasPrefix(rest, o) {
+					op = o
+					break
+				}
+			}
+			if key == "" || op == "" {
+				i = start
+				word := readBare()
+				switch strings.ToLower(word) {
+				case "or":
+					tokens = append(tokens, token{typ: tokOr})
+				case "and":
+					tokens = append(tokens, token{typ: tokAnd})
+				default:
+					tokens = append(tokens, token{typ: tokTerm, value: word})
+				}
+				continue
+			}
+			i += len([]rune(op))
+			var value string
+			if i < len(runes) && runes[i] == '"' {
+				s, err := readQuoted()
+				if err != nil {
+					return nil, err
+				}
+				value = s
+			} else {
+				value = readBare()
+			}
+			if value == "" {
+				return nil, fmt.Errorf("Missing value for '%s%s'", key, op)
+			}
+			tokens = append(tokens, token{typ: tokTerm, key: key, op: op, value: value})
+		}
+	}
+	tokens = append(tokens, token{typ: tokEOF})
+	return tokens, nil
+}
+
+type queryParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *queryParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) parseOr() (Query, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orQuery{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (Query, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().typ {
+		case tokEOF, tokRParen, tokOr:
+			return left, nil
+		case tokAnd:
+			p.next()
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andQuery{left, right}
+	}
+}
+
+func (p *queryParser) parseUnary() (Query, error) {
+	if p.peek().typ == tokNot {
+		p.next()
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notQuery{q}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (Query, error) {
+	t := p.next()
+	switch t.typ {
+	case tokLParen:
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().typ != tokRParen {
+			return nil, fmt.Errorf("Missing closing parenthesis")
+		}
+		return q, nil
+	case tokTerm:
+		return compileTerm(t)
+	case tokRParen:
+		return nil, fmt.Errorf("Unexpected closing parenthesis")
+	case tokEOF:
+		return nil, fmt.Errorf("Unexpected end of query")
+	default:
+		return nil, fmt.Errorf("Unexpected operator in query")
+	}
+}
+
+// ParseQuery parses a search expression like "c:rg t:creature cmc<=3" into
+// a Query.
+func ParseQuery(input string) (Query, error) {
+	tokens, err := lexQuery(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	if p.peek().typ == tokEOF {
+		return nil, fmt.Errorf("Empty query")
+	}
+	q, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ != tokEOF {
+		return nil, fmt.Errorf("Unexpected closing parenthesis")
+	}
+	return q, nil
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+func compileTerm(t token) (Query, error) {
+	if t.key == "" {
+		return termQuery{`name LIKE ? ESCAPE '\'`, []interface{}{"%" + escapeLike(t.value) + "%"}}, nil
+	}
+	field, ok := queryFields[t.key]
+	if !ok {
+		return nil, fmt.Errorf("Unknown search field '%s'", t.key)
+	}
+	switch field.kind {
+	case textField:
+		return compileText(field.column, t.op, t.value)
+	case exactField:
+		return compileExact(field.column, t.op, t.value)
+	case numericField:
+		return compileNumeric(field.column, t.op, t.value)
+	case colorField:
+		return compileColor(field.column, t.op, t.value)
+	case rarityField:
+		return compileRarity(t.op, t.value)
+	case legalityField:
+		return compileLegality(field.column, t.op, t.value)
+	}
+	return nil, fmt.Errorf("Unsupported search field '%s'", t.key)
+}
+
+func compileText(column, op, value string) (Query, error) {
+	switch op {
+	case ":":
+		return termQuery{column + ` LIKE ? ESCAPE '\'`, []interface{}{"%" + escapeLike(value) + "%"}}, nil
+	case "=":
+		return termQuery{column + ` LIKE ? ESCAPE '\'`, []interface{}{escapeLike(value)}}, nil
+	case "!=":
+		return termQuery{column + ` NOT LIKE ? ESCAPE '\'`, []interface{}{escapeLike(value)}}, nil
+	}
+	return nil, fmt.Errorf("Operator '%s' not supported for %s", op, column)
+}
+
+func compileExact(column, op, value string) (Query, error) {
+	switch op {
+	case ":", "=":
+		return termQuery{"lower(" + column + ") = ?", []interface{}{strings.ToLower(value)}}, nil
+	case "!=":
+		return termQuery{"lower(" + column + ") != ?", []interface{}{strings.ToLower(value)}}, nil
+	}
+	return nil, fmt.Errorf("Operator '%s' not supported for %s", op, column)
+}
+
+func compileNumeric(column, op, value string) (Query, error) {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid number for %s: '%s'", column, value)
+	}
+	if op == ":" {
+		op = "="
+	}
+	return termQuery{fmt.Sprintf("CAST(%s AS REAL) %s ?", column, op), []interface{}{n}}, nil
+}
+
+func colorLike(column, color string) string {
+	return fmt.Sprintf("%s LIKE '%%%s%%'", column, color)
+}
+
+func compileColor(column, op, value string) (Query, error) {
+	v := strings.ToLower(value)
+	switch v {
+	case "c", "colorless":
+		if op != ":" && op != "=" {
+			return nil, fmt.Errorf("Operator '%s' not supported for colorless", op)
+		}
+		return termQuery{fmt.Sprintf("(%s IS NULL OR %s = '')", column, column), nil}, nil
+	case "m", "multicolor":
+		if op != ":" && op != "=" {
+			return nil, fmt.Errorf("Operator '%s' not supported for multicolor", op)
+		}
+		return termQuery{column + " LIKE '%,%'", nil}, nil
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range colorNames {
+		if v == name {
+			wanted[name] = true
+		}
+	}
+	if len(wanted) == 0 {
+		for _, r := range v {
+			name, ok := colorAbbrevs[r]
+			if !ok {
+				return nil, fmt.Errorf("Unknown color '%s'", value)
+			}
+			wanted[name] = true
+		}
+	}
+
+	has, lacks := []string{}, []string{}
+	for _, name := range colorNames {
+		if wanted[name] {
+			has = append(has, colorLike(column, name))
+		} else {
+			lacks = append(lacks, "NOT "+colorLike(column, name))
+		}
+	}
+	includesAny := strings.Join(has, " OR ")
+	includesAll := strings.Join(has, " AND ")
+	excludesOthers := "1"
+	if len(lacks) > 0 {
+		excludesOthers = strings.Join(lacks, " AND ")
+	}
+
+	switch op {
+	case ":":
+		return termQuery{includesAny, nil}, nil
+	case ">=":
+		return termQuery{includesAll, nil}, nil
+	case "=":
+		return termQuery{includesAll + " AND " + excludesOthers, nil}, nil
+	case "!=":
+		return termQuery{"NOT (" + includesAll + " AND " + excludesOthers + ")", nil}, nil
+	case "<=":
+		return termQuery{excludesOthers, nil}, nil
+	case "<":
+		return termQuery{excludesOthers + " AND NOT (" + includesAll + ")", nil}, nil
+	case ">":
+		return termQuery{includesAll + " AND NOT (" + excludesOthers + ")", nil}, nil
+	}
+	return nil, fmt.Errorf("Operator '%s' not supported for colors", op)
+}
+
+func compileRarity(op, value string) (Query, error) {
+	rarity, ok := rarityNames[strings.ToLower(value)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown rarity '%s'", value)
+	}
+	switch op {
+	case ":", "=":
+		return termQuery{"lower(rarity) = ?", []interface{}{rarity}}, nil
+	case "!=":
+		return termQuery{"lower(rarity) != ?", []interface{}{rarity}}, nil
+	}
+	return termQuery{fmt.Sprintf("%s %s (%s)", rarityRank, op, strings.Replace(rarityRank, "lower(rarity)", "?", 1)), []interface{}{rarity}}, nil
+}
+
+// For legality fields the column is the list of matching legality values.
+func compileLegality(statuses, op, value string) (Query, error) {
+	sub := "mtg_json_id IN (SELECT mtg_json_id FROM legality WHERE format = ? AND legality IN (" + statuses + "))"
+	switch op {
+	case ":", "=":
+		return termQuery{sub, []interface{}{strings.ToLower(value)}}, nil
+	case "!=":
+		return termQuery{"NOT " + sub, []interface{}{strings.ToLower(value)}}, nil
+	}
+	return nil, fmt.Errorf("Operator '%s' not supported for formats", op)
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/mtgjson"
+	// import sqlite driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestParseQuerySQL(t *testing.T) {
+	tests := []struct {
+		query string
+		sql   string
+		args  []interface{}
+	}{
+		{
+			"cmc<=3",
+			"(CAST(cmc AS REAL) <= ?)",
+			[]interface{}{3.0},
+		},
+		{
+			"t:creature -o:sacrifice",
+			`((type LIKE ? ESCAPE '\') AND NOT (text LIKE ? ESCAPE '\'))`,
+			[]interface{}{"%creature%", "%sacrifice%"},
+		},
+		{
+			`(s:lea or s:leb) "air elemental"`,
+			`(((lower(set_code) = ?) OR (lower(set_code) = ?)) AND (name LIKE ? ESCAPE '\'))`,
+			[]interface{}{"lea", "leb", "%air elemental%"},
+		},
+		{
+			`o:"draw a card" and r:m`,
+			`((text LIKE ? ESCAPE '\') AND (lower(rarity) = ?))`,
+			[]interface{}{"%draw a card%", "mythic rare"},
+		},
+		{
+			"c:rg",
+			"(colors LIKE '%red%' OR colors LIKE '%green%')",
+			nil,
+		},
+		{
+			"c>=rg",
+			"(colors LIKE '%red%' AND colors LIKE '%green%')",
+			nil,
+		},
+	}
+
+	for _, tc := range tests {
+		q, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Errorf("'%s' :: unexpected error: %s", tc.query, err)
+			continue
+		}
+		sql, args := q.SQL()
+		if sql != tc.sql {
+			t.Errorf("'%s' :: expected SQL %s got %s", tc.query, tc.sql, sql)
+		}
+		if len(args) != 0 || len(tc.args) != 0 {
+			if !reflect.DeepEqual(args, tc.args) {
+				t.Errorf("'%s' :: expected args %v got %v", tc.query, tc.args, args)
+			}
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	bad := []string{
+		"",
+		"(t:creature",
+		"t:creature)",
+		"cmc<=three",
+		"foo:bar",
+		`o:"unterminated`,
+		"c:xyz",
+		"t<creature",
+		"t:creature or",
+	}
+	for _, q := range bad {
+		if _, err := ParseQuery(q); err == nil {
+			t.Errorf("'%s' :: expected an error", q)
+		}
+	}
+}
+
+func TestSearchCardsQuery(t *testing.T) {
+	dbh := MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	sets := map[string]mtgjson.Set{
+		"TST": {
+			Name: "Test Set",
+			Code: "TST",
+			Cards: []*mtgjson.Card{
+				{MTGJsonID: "1", Name: "Raging Goblin", Type: "Creature — Goblin", Colors: mtgjson.StringSlice{"red"}, CMC: 1, Text: "Haste", Rarity: "Common"},
+				{MTGJsonID: "2", Name: "Mogg Fanatic", Type: "Creature — Goblin", Colors: mtgjson.StringSlice{"red"}, CMC: 1, Text: "Sacrifice Mogg Fanatic: It deals 1 damage to any target.", Rarity: "Common"},
+				{MTGJsonID: "3", Name: "Giant Growth", Type: "Instant", Colors: mtgjson.StringSlice{"green"}, CMC: 1, Text: "Target creature gets +3/+3 until end of turn.", Rarity: "Common"},
+				{MTGJsonID: "4", Name: "Craterhoof Behemoth", Type: "Creature — Beast", Colors: mtgjson.StringSlice{"green"}, CMC: 8, Text: "Haste", Rarity: "Mythic Rare",
+					Legalities: []mtgjson.Legality{{Format: "modern", Legality: "legal"}, {Format: "commander", Legality: "banned"}}},
+			},
+		},
+	}
+	sets["TS2"] = mtgjson.Set{
+		Name: "Test Set 2",
+		Code: "TS2",
+		Cards: []*mtgjson.Card{
+			{MTGJsonID: "5", Name: "Raging Goblin", SetCode: "TS2", ReleaseDate: time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC), Type: "Creature — Goblin", Colors: mtgjson.StringSlice{"red"}, CMC: 1, Text: "Haste", Rarity: "Common"},
+		},
+	}
+	err := SaveCards(dbh, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cards, _, err := SearchCardsQuery(dbh, "c:rg t:creature cmc<=3 o:haste -o:sacrifice", SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 2 || cards[0].Name != "Raging Goblin" {
+		t.Fatalf("Expected two Raging Goblin printings, got %v", cards)
+	}
+
+	cards, _, err = SearchCardsQuery(dbh, "raging goblin", SearchOptions{Unique: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].SetCode != "TS2" {
+		t.Fatalf("Expected only the TS2 Raging Goblin, got %v", cards)
+	}
+
+	cards, _, err = SearchCardsQuery(dbh, "r>=rare or t:instant", SearchOptions{Sort: "cmc", Desc: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 2 {
+		t.Fatalf("Expected 2 cards, got %d", len(cards))
+	}
+	if cards[0].Name != "Craterhoof Behemoth" {
+		t.Fatalf("Expected highest cmc card first, got %s", cards[0].Name)
+	}
+
+	cards, _, err = SearchCardsQuery(dbh, "f:modern -banned:commander or banned:commander", SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Name != "Craterhoof Behemoth" {
+		t.Fatalf("Expected only Craterhoof Behemoth, got %v", cards)
+	}
+	err = AddLegalities(dbh, &cards[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards[0].Legalities) != 2 {
+		t.Fatalf("Expected 2 legalities, got %v", cards[0].Legalities)
+	}
+
+	cards, _, err = SearchCards(dbh, []string{"format"}, [][]string{{"modern"}}, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 {
+		t.Fatalf("Expected 1 modern legal card, got %d", len(cards))
+	}
+
+	cards, total, err := SearchCardsQuery(dbh, "t:creature", SearchOptions{Sort: "name", Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Name != "Mogg Fanatic" {
+		t.Fatalf("Expected only Mogg Fanatic, got %v", cards)
+	}
+	if total != 4 {
+		t.Fatalf("Expected a total of 4 creature printings, got %d", total)
+	}
+}
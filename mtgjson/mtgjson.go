@@ -2,10 +2,8 @@ package mtgjson
 
 import (
 	"database/sql/driver"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"strings"
 	"time"
 )
@@ -22,6 +20,9 @@ func (s StringSlice) Value() (driver.Value, error) {
 func (s *StringSlice) Scan(src interface{}) error {
 	var source string
 	switch src.(type) {
+	case nil:
+		*s = StringSlice{}
+		return nil
 	case string:
 		source = src.(string)
 	case []byte:
@@ -29,6 +30,10 @@ func (s *StringSlice) Scan(src interface{}) error {
 	default:
 		return errors.New("Incpompatible type for StringSlice")
 	}
+	if source == "" {
+		*s = StringSlice{}
+		return nil
+	}
 	*s = StringSlice(strings.Split(source, ","))
 	return nil
 }
@@ -45,15 +50,15 @@ func (s StringSlice) ToLower() StringSlice {
 type Set struct {
 	Name               string  `json:"name"`
 	Code               string  `json:"code"`
-	GathererCode       string  `json:"gathererCode,omitempty"`
-	OldCode            string  `json:"oldCode,omitempty"`
-	MagicCardsInfoCode string  `json:"magicCardsInfoCode,omitempty"`
-	ReleaseDate        string  `json:"releaseDate"`
+	GathererCode       string  `json:"gathererCode,omitempty" db:"gatherer_code"`
+	OldCode            string  `json:"oldCode,omitempty" db:"old_code"`
+	MagicCardsInfoCode string  `json:"magicCardsInfoCode,omitempty" db:"magic_cards_info_code"`
+	ReleaseDate        string  `json:"releaseDate" db:"release_date"`
 	Border             string  `json:"border"`
-	SetType            string  `json:"type"`
+	SetType            string  `json:"type" db:"type"`
 	Block              string  `json:"block"`
-	OnlineOnly         bool    `json:"onlineOnly,omitempty"`
-	Cards              []*Card `json:"cards"`
+	OnlineOnly         bool    `json:"onlineOnly,omitempty" db:"online_only"`
+	Cards              []*Card `json:"cards" db:"-"`
 }
 
 // Card represents a Magic Card from a particular set
@@ -83,8 +88,10 @@ type Card struct {
 	Types      StringSlice `json:"types"`
 	Subtypes   StringSlice `json:"subtypes" db:"sub_types"`
 	Colors     StringSlice `json:"colors"`
-	Rarity     string      `json:"rarity"`
-	Text       string      `json:"text"`
+	// ColorIdentity holds single letter color codes, e.g. ["U", "R"]
+	ColorIdentity StringSlice `json:"colorIdentity" db:"color_identity"`
+	Rarity        string      `json:"rarity"`
+	Text          string      `json:"text"`
 
 	Timeshifted bool `json:"timeshifted,omitempty"`
 	Reserved    bool `json:"reserved,omitempty"`
@@ -95,17 +102,19 @@ type Card struct {
 	MultiverseID int    `json:"multiverseid" db:"multiverse_id"`
 	Number       string `json:"number"`
 	//	Variations   []int  `json:"variations,omitempty"` // MULTIVID
-	Source    string `json:"source,omitempty"`
-	Watermark string `json:"watermark,omitempty"`
-	Artist    string `json:"artist"`
-	ImageName string `json:"imageName" db:"image_name"`
-	//Legalities   []Legality `json:"legalities"`
-	//Rulings      []Ruling   `json:"rulings,omitempty"`
+	Source     string     `json:"source,omitempty"`
+	Watermark  string     `json:"watermark,omitempty"`
+	Artist     string     `json:"artist"`
+	ImageName  string     `json:"imageName" db:"image_name"`
+	Legalities []Legality `json:"legalities,omitempty" db:"-"`
+	Rulings    []Ruling   `json:"rulings,omitempty" db:"-"`
 	//	Printings []string `json:"printings"`
 
 	URL      string `json:"url,omitempty"`
 	ImageURL string `json:"image_url,omitempty"`
 	SetURL   string `json:"set_url,omitempty"`
+	// Snippet highlights the text matching a full text search
+	Snippet string `json:"snippet,omitempty"`
 }
 
 // IsBasicLand returns true if the card is a basic land type
@@ -113,12 +122,14 @@ func (c *Card) IsBasicLand() bool {
 	return c.Rarity == "Basic Land"
 }
 
+// Legality is the status of a card in a particular format
 type Legality struct {
 	Format   string `json:"format"`
 	Legality string `json:"legality"`
 	//Condition string `json:"condition,omitempty"`
 }
 
+// Ruling is an official clarification of how a card works
 type Ruling struct {
 	Date string `json:"date"`
 	Text string `json:"text"`
@@ -131,6 +142,12 @@ type ForeignName struct {
 }
 
 func processCard(card *Card) {
+	for i, l := range card.Legalities {
+		card.Legalities[i] = Legality{
+			Format:   strings.ToLower(l.Format),
+			Legality: strings.ToLower(l.Legality),
+		}
+	}
 	card.Names = card.Names.ToLower()
 	card.Supertypes = card.Supertypes.ToLower()
 	card.Types = card.Types.ToLower()
@@ -138,32 +155,32 @@ func processCard(card *Card) {
 	card.Colors = card.Colors.ToLower()
 }
 
-// LoadCollection unmarshals a mtgjson.com data dump into Set & Card structs
+// LoadCollection unmarshals a mtgjson.com data dump into Set & Card structs.
+// Both the v3 AllSets and v5 AllPrintings formats are understood.  For large
+// dumps use WalkCollection to avoid holding every set in memory.
 func LoadCollection(path string) (map[string]Set, error) {
-	blob, err := ioutil.ReadFile(path)
 	setmap := make(map[string]Set)
+	err := WalkCollection(path, func(set Set) error {
+		setmap[set.Code] = set
+		return nil
+	})
+	return setmap, err
+}
 
+// prepareSet copies set level information onto each card and normalizes the
+// card fields for storage.
+func prepareSet(set *Set) error {
+	reldate, err := parseDate(set.ReleaseDate)
 	if err != nil {
-		return setmap, err
-	}
-
-	err = json.Unmarshal(blob, &setmap)
-	if err != nil {
-		return nil, err
+		return fmt.Errorf("Error parsing set %s: %s", set.Code, err)
 	}
-	for _, set := range setmap {
-		reldate, err := parseDate(set.ReleaseDate)
-		if err != nil {
-			return setmap, fmt.Errorf("Error parsing set %s: %s", set.Code, err)
-		}
-		for _, card := range set.Cards {
-			card.SetCode = set.Code
-			card.SetName = set.Name
-			card.ReleaseDate = reldate
-			processCard(card)
-		}
+	for _, card := range set.Cards {
+		card.SetCode = set.Code
+		card.SetName = set.Name
+		card.ReleaseDate = reldate
+		processCard(card)
 	}
-	return setmap, err
+	return nil
 }
 
 func parseDate(s string) (time.Time, error) {
@@ -1,7 +1,14 @@
 package mtgjson
 
 import (
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 )
@@ -24,4 +31,147 @@ func TestLoadCardJSON(t *testing.T) {
 		t.Fatalf("Expected 1 card got %d", len(set.Cards))
 	}
 	spew.Dump(set.Cards)
+
+	legalities := set.Cards[0].Legalities
+	if len(legalities) != 4 {
+		t.Fatalf("Expected 4 legalities got %d", len(legalities))
+	}
+	if legalities[0].Format != "commander" || legalities[0].Legality != "legal" {
+		t.Fatalf("Expected legalities to be lowercased, got %v", legalities[0])
+	}
+}
+
+func TestLoadV5CardJSON(t *testing.T) {
+	collection, err := LoadCollection("testsets_v5.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collection) != 2 {
+		t.Fatalf("Expected 2 sets got %d", len(collection))
+	}
+
+	set := collection["LEA"]
+	if set.Block != "Core Set" || set.Border != "black" {
+		t.Fatalf("Set fields not mapped: %#v", set)
+	}
+	if len(set.Cards) != 2 {
+		t.Fatalf("Expected 2 cards got %d", len(set.Cards))
+	}
+	air := set.Cards[0]
+	if air.MTGJsonID != "de2f7b1a-1c2d-5e3f-8a9b-0c1d2e3f4a5b" || air.MultiverseID != 94 {
+		t.Fatalf("Identifiers not mapped: %s %d", air.MTGJsonID, air.MultiverseID)
+	}
+	if len(air.Colors) != 1 || air.Colors[0] != "blue" {
+		t.Fatalf("Expected colors [blue] got %v", air.Colors)
+	}
+	if air.Rarity != "Uncommon" || air.CMC != 5 || air.Flavor == "" {
+		t.Fatalf("Card fields not mapped: %#v", air)
+	}
+	if len(air.Legalities) != 4 || air.Legalities[0].Format != "commander" || air.Legalities[0].Legality != "legal" {
+		t.Fatalf("Legalities not mapped: %v", air.Legalities)
+	}
+	if !set.Cards[1].IsBasicLand() {
+		t.Fatal("Expected Forest to be a basic land")
+	}
+
+	fire := collection["APC"].Cards[0]
+	if fire.Name != "Fire" || len(fire.Names) != 2 {
+		t.Fatalf("Split card names not mapped: %s %v", fire.Name, fire.Names)
+	}
+	if !fire.ReleaseDate.Equal(time.Date(2001, 6, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Release date not set: %v", fire.ReleaseDate)
+	}
+}
+
+func TestLoadCompressedJSON(t *testing.T) {
+	blob, err := ioutil.ReadFile("testsets.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "mtgjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gzPath := filepath.Join(dir, "AllSets.json.gz")
+	f, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write(blob)
+	gz.Close()
+	f.Close()
+
+	zipPath := filepath.Join(dir, "AllSets.json.zip")
+	f, err = os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("AllSets.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(blob)
+	zw.Close()
+	f.Close()
+
+	// bzip2 and xz can't be written by the standard library so their
+	// fixtures are checked in
+	for _, path := range []string{gzPath, zipPath, "testsets.json.bz2", "testsets.json.xz"} {
+		sets := 0
+		err = WalkCollection(path, func(set Set) error {
+			sets++
+			if set.Code != "LEA" || len(set.Cards) != 1 {
+				t.Errorf("%s :: unexpected set %s with %d cards", path, set.Code, len(set.Cards))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s :: %s", path, err)
+		}
+		if sets != 1 {
+			t.Fatalf("%s :: expected 1 set got %d", path, sets)
+		}
+	}
+}
+
+func TestStringSliceScan(t *testing.T) {
+	tests := map[interface{}]int{
+		nil:   0,
+		"":    0,
+		"R":   1,
+		"W,U": 2,
+	}
+	for src, expected := range tests {
+		s := StringSlice{"old"}
+		err := s.Scan(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s) != expected {
+			t.Errorf("Expected %v to scan to %d values got %v", src, expected, s)
+		}
+	}
+}
+
+func TestWalkPrices(t *testing.T) {
+	prices := []Price{}
+	err := WalkPrices("testprices.json", func(p []Price) error {
+		prices = append(prices, p...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Price{
+		{MTGJsonID: "de2f7b1a-1c2d-5e3f-8a9b-0c1d2e3f4a5b", Vendor: "cardmarket", Finish: "normal", Date: "2024-01-02", Price: 310.5, Currency: "EUR"},
+		{MTGJsonID: "de2f7b1a-1c2d-5e3f-8a9b-0c1d2e3f4a5b", Vendor: "tcgplayer", Finish: "normal", Date: "2024-01-01", Price: 349.99, Currency: "USD"},
+		{MTGJsonID: "de2f7b1a-1c2d-5e3f-8a9b-0c1d2e3f4a5b", Vendor: "tcgplayer", Finish: "normal", Date: "2024-01-02", Price: 355.0, Currency: "USD"},
+	}
+	if !reflect.DeepEqual(prices, expected) {
+		t.Fatalf("Expected only paper retail prices:\n%v\ngot\n%v", expected, prices)
+	}
 }
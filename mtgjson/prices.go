@@ -0,0 +1,119 @@
+package mtgjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Price is the retail price of one printing of a card from a vendor on a
+// particular day.
+type Price struct {
+	MTGJsonID string  `json:"uuid" db:"mtg_json_id"`
+	Vendor    string  `json:"vendor"`
+	Finish    string  `json:"finish"`
+	Date      string  `json:"date"`
+	Price     float64 `json:"price"`
+	Currency  string  `json:"currency"`
+}
+
+// v5VendorPrices is one vendor's prices for a card in AllPrices, e.g.
+//
+//	"tcgplayer": {"currency": "USD", "retail": {"normal": {"2024-01-01": 0.25}}}
+type v5VendorPrices struct {
+	Currency string                        `json:"currency"`
+	Retail   map[string]map[string]float64 `json:"retail"`
+}
+
+// v5CardPrices holds a card's prices by platform and vendor.  Only paper
+// prices are kept.
+type v5CardPrices struct {
+	Paper map[string]v5VendorPrices `json:"paper"`
+}
+
+func (p v5CardPrices) prices(uuid string) []Price {
+	prices := []Price{}
+	for vendor, vp := range p.Paper {
+		for finish, days := range vp.Retail {
+			for date, price := range days {
+				prices = append(prices, Price{
+					MTGJsonID: uuid,
+					Vendor:    vendor,
+					Finish:    finish,
+					Date:      date,
+					Price:     price,
+					Currency:  vp.Currency,
+				})
+			}
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		a, b := prices[i], prices[j]
+		if a.Vendor != b.Vendor {
+			return a.Vendor < b.Vendor
+		}
+		if a.Finish != b.Finish {
+			return a.Finish < b.Finish
+		}
+		return a.Date < b.Date
+	})
+	return prices
+}
+
+// WalkPrices streams the paper retail prices in a mtgjson.com AllPrices or
+// AllPricesToday file, calling fn with the prices of each card in turn.
+// Compressed files are handled as in OpenCollection.
+func WalkPrices(path string, fn func([]Price) error) error {
+	r, err := OpenCollection(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	dec := json.NewDecoder(r)
+
+	err = expectDelim(dec, '{')
+	if err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if t.(string) != "data" {
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = expectDelim(dec, '{')
+		if err != nil {
+			return err
+		}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			uuid := t.(string)
+			cp := v5CardPrices{}
+			err = dec.Decode(&cp)
+			if err != nil {
+				return fmt.Errorf("Error parsing prices for %s: %s", uuid, err)
+			}
+			if prices := cp.prices(uuid); len(prices) > 0 {
+				err = fn(prices)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err = expectDelim(dec, '}')
+		if err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
@@ -0,0 +1,177 @@
+package mtgjson
+
+import (
+	"archive/zip"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// multiCloser closes a decompressor along with its underlying file
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiCloser) Close() error {
+	var firstErr error
+	for _, c := range m.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// OpenCollection opens a mtgjson.com data dump, transparently decompressing
+// .gz, .bz2, .xz and .zip files based on their extension.  Zip archives must
+// contain a single .json file.
+func OpenCollection(path string) (io.ReadCloser, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".zip" {
+		return openZip(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(f)
+
+	switch ext {
+	case ".gz":
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Error reading gzip file %s: %s", path, err)
+		}
+		return &multiCloser{gz, []io.Closer{gz, f}}, nil
+	case ".bz2":
+		return &multiCloser{bzip2.NewReader(buffered), []io.Closer{f}}, nil
+	case ".xz":
+		xzr, err := xz.NewReader(buffered)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("Error reading xz file %s: %s", path, err)
+		}
+		return &multiCloser{xzr, []io.Closer{f}}, nil
+	default:
+		return &multiCloser{buffered, []io.Closer{f}}, nil
+	}
+}
+
+func openZip(path string) (io.ReadCloser, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, zf := range zr.File {
+		if strings.HasSuffix(strings.ToLower(zf.Name), ".json") {
+			r, err := zf.Open()
+			if err != nil {
+				zr.Close()
+				return nil, err
+			}
+			return &multiCloser{r, []io.Closer{r, zr}}, nil
+		}
+	}
+	zr.Close()
+	return nil, fmt.Errorf("No .json file found in %s", path)
+}
+
+// WalkCollection streams the sets in a mtgjson.com data dump, calling fn with
+// each one as it is decoded so that only a single set is held in memory at a
+// time.  Both the v3 AllSets and v5 AllPrintings formats are understood.
+func WalkCollection(path string, fn func(Set) error) error {
+	r, err := OpenCollection(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return walkSets(json.NewDecoder(r), fn)
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := t.(json.Delim); !ok || d != want {
+		return fmt.Errorf("Expected '%s' in mtgjson data got %v", want, t)
+	}
+	return nil
+}
+
+// walkSets reads the top level object.  v3 files are a map of set code to
+// set, v5 files wrap that map in the "data" key next to a "meta" key.
+func walkSets(dec *json.Decoder, fn func(Set) error) error {
+	err := expectDelim(dec, '{')
+	if err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := t.(string)
+		switch key {
+		case "meta":
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		case "data":
+			err = walkV5Sets(dec, fn)
+		default:
+			set := Set{}
+			err = dec.Decode(&set)
+			if err != nil {
+				return fmt.Errorf("Error parsing set %s: %s", key, err)
+			}
+			err = emitSet(&set, fn)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func walkV5Sets(dec *json.Decoder, fn func(Set) error) error {
+	err := expectDelim(dec, '{')
+	if err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		v5 := v5Set{}
+		err = dec.Decode(&v5)
+		if err != nil {
+			return fmt.Errorf("Error parsing set %s: %s", t, err)
+		}
+		set := v5.toSet()
+		err = emitSet(&set, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func emitSet(set *Set, fn func(Set) error) error {
+	err := prepareSet(set)
+	if err != nil {
+		return err
+	}
+	return fn(*set)
+}
@@ -0,0 +1,166 @@
+package mtgjson
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+* Support for the MTGJSON v5 AllPrintings.json format.  Sets are wrapped in a
+* {"meta": ..., "data": {...}} envelope and cards use a different set of field
+* names, which are mapped onto the v3 shaped Set and Card structs here.
+ */
+
+type v5Set struct {
+	Name         string    `json:"name"`
+	Code         string    `json:"code"`
+	GathererCode string    `json:"gathererCode"`
+	ReleaseDate  string    `json:"releaseDate"`
+	Type         string    `json:"type"`
+	Block        string    `json:"block"`
+	IsOnlineOnly bool      `json:"isOnlineOnly"`
+	Cards        []*v5Card `json:"cards"`
+}
+
+type v5Card struct {
+	UUID              string            `json:"uuid"`
+	Name              string            `json:"name"`
+	FaceName          string            `json:"faceName"`
+	Layout            string            `json:"layout"`
+	Power             string            `json:"power"`
+	Toughness         string            `json:"toughness"`
+	Loyalty           string            `json:"loyalty"`
+	Hand              string            `json:"hand"`
+	Life              string            `json:"life"`
+	ManaValue         *float32          `json:"manaValue"`
+	ConvertedManaCost float32           `json:"convertedManaCost"`
+	ManaCost          string            `json:"manaCost"`
+	Type              string            `json:"type"`
+	Supertypes        []string          `json:"supertypes"`
+	Types             []string          `json:"types"`
+	Subtypes          []string          `json:"subtypes"`
+	Colors            []string          `json:"colors"`
+	ColorIdentity     []string          `json:"colorIdentity"`
+	Rarity            string            `json:"rarity"`
+	Text              string            `json:"text"`
+	FlavorText        string            `json:"flavorText"`
+	IsTimeshifted     bool              `json:"isTimeshifted"`
+	IsReserved        bool              `json:"isReserved"`
+	IsStarter         bool              `json:"isStarter"`
+	Number            string            `json:"number"`
+	Watermark         string            `json:"watermark"`
+	Artist            string            `json:"artist"`
+	BorderColor       string            `json:"borderColor"`
+	Identifiers       map[string]string `json:"identifiers"`
+	Legalities        map[string]string `json:"legalities"`
+	Rulings           []Ruling          `json:"rulings"`
+}
+
+var v5Colors = map[string]string{
+	"W": "White",
+	"U": "Blue",
+	"B": "Black",
+	"R": "Red",
+	"G": "Green",
+}
+
+func atoiOrZero(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func (s *v5Set) toSet() Set {
+	set := Set{
+		Name:         s.Name,
+		Code:         s.Code,
+		GathererCode: s.GathererCode,
+		ReleaseDate:  s.ReleaseDate,
+		SetType:      s.Type,
+		Block:        s.Block,
+		OnlineOnly:   s.IsOnlineOnly,
+		Cards:        make([]*Card, len(s.Cards)),
+	}
+	for i, c := range s.Cards {
+		if set.Border == "" {
+			set.Border = c.BorderColor
+		}
+		set.Cards[i] = c.toCard()
+	}
+	return set
+}
+
+func (c *v5Card) toCard() *Card {
+	card := &Card{
+		MTGJsonID:     c.UUID,
+		Layout:        c.Layout,
+		Power:         c.Power,
+		Toughness:     c.Toughness,
+		Loyalty:       atoiOrZero(c.Loyalty),
+		Hand:          atoiOrZero(c.Hand),
+		Life:          atoiOrZero(c.Life),
+		CMC:           c.ConvertedManaCost,
+		ManaCost:      c.ManaCost,
+		Name:          c.Name,
+		Type:          c.Type,
+		Supertypes:    StringSlice(c.Supertypes),
+		Types:         StringSlice(c.Types),
+		Subtypes:      StringSlice(c.Subtypes),
+		ColorIdentity: StringSlice(c.ColorIdentity),
+		Text:          c.Text,
+		Timeshifted:   c.IsTimeshifted,
+		Reserved:      c.IsReserved,
+		Starter:       c.IsStarter,
+		Flavor:        c.FlavorText,
+		MultiverseID:  atoiOrZero(c.Identifiers["multiverseId"]),
+		Number:        c.Number,
+		Watermark:     c.Watermark,
+		Artist:        c.Artist,
+		Rulings:       c.Rulings,
+	}
+	if c.ManaValue != nil {
+		card.CMC = *c.ManaValue
+	}
+	// v3 named each face of split and double faced cards separately
+	if c.FaceName != "" {
+		card.Name = c.FaceName
+		card.Names = StringSlice(strings.Split(c.Name, " // "))
+	}
+	for _, color := range c.Colors {
+		if name, ok := v5Colors[color]; ok {
+			card.Colors = append(card.Colors, name)
+		}
+	}
+	card.Rarity = v5Rarity(c)
+
+	formats := make([]string, 0, len(c.Legalities))
+	for f := range c.Legalities {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	for _, f := range formats {
+		card.Legalities = append(card.Legalities, Legality{Format: f, Legality: c.Legalities[f]})
+	}
+	return card
+}
+
+// v5Rarity converts to the v3 rarity names the rest of mtgbrew expects
+func v5Rarity(c *v5Card) string {
+	for _, st := range c.Supertypes {
+		if st == "Basic" {
+			for _, t := range c.Types {
+				if t == "Land" {
+					return "Basic Land"
+				}
+			}
+		}
+	}
+	switch c.Rarity {
+	case "mythic":
+		return "Mythic Rare"
+	case "":
+		return ""
+	default:
+		return strings.ToUpper(c.Rarity[:1]) + c.Rarity[1:]
+	}
+}
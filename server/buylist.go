@@ -1,9 +1,10 @@
 package server
 
 import (
-	"bufio"
+	"database/sql"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"regexp"
 	"sort"
@@ -17,38 +18,60 @@ import (
 	"github.com/labstack/echo"
 )
 
-func readerToDeck(file io.Reader, excludebasic bool, dbh *db.Handle) (DeckList, []error) {
-	t := time.Now()
-	scanner := bufio.NewScanner(file)
-	deck := DeckList{}
-	errs := []error{}
-	linecount := 0
+// readDeck parses a deck list in any of the formats in deckReaders, looks up
+// each card and hands it to add along with the entry it was read from.
+func readDeck(file io.Reader, dbh *db.Handle, add func(card *mtgjson.Card, e DeckEntry) error) []error {
+	reader, file := detectDeckReader(file)
+	return readDeckAs(reader, file, dbh, add)
+}
 
-	for scanner.Scan() {
-		name, count, err := parseLine(scanner.Text())
-		linecount++
+// readDeckAs is like readDeck for a deck list in a known format
+func readDeckAs(reader DeckReader, file io.Reader, dbh *db.Handle, add func(card *mtgjson.Card, e DeckEntry) error) []error {
+	t := time.Now()
+	entries, errs := reader.Read(file)
+	for _, e := range entries {
+		card, err := db.ResolveCard(dbh, e.Name)
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
-		if name == "" {
-			continue
+		if e.Set != "" {
+			// Formats like Arena use their own set codes for some sets so
+			// fall back to the latest printing if there isn't a match.
+			printing, err := db.CardPrinting(dbh, card.Name, e.Set, e.Number)
+			if err == nil {
+				card = printing
+			} else if err == sql.ErrNoRows {
+				e.Set, e.Number = "", ""
+			} else {
+				errs = append(errs, err)
+				continue
+			}
 		}
-		card, err := db.CardByName(dbh, name)
+		err = add(card, e)
 		if err != nil {
-			errs = append(errs, fmt.Errorf("Unknown card: '%s' (%s)", name, err))
-			continue
+			errs = append(errs, err)
 		}
+	}
+	logrus.Infof("Took %v seconds to process %d %s deck entries", time.Now().Sub(t), len(entries), reader.Format())
+	return errs
+}
+
+// readerToDeck reads a deck list keeping each card's section, see
+// DeckList.BuyList for the combined quantities.
+func readerToDeck(file io.Reader, excludebasic bool, dbh *db.Handle) (DeckList, []error) {
+	deck := DeckList{}
+	errs := readDeck(file, dbh, func(card *mtgjson.Card, e DeckEntry) error {
 		if excludebasic && card.IsBasicLand() {
-			continue
+			return nil
 		}
-
-		err = deck.AddCard(card, count)
-		if err != nil {
-			errs = append(errs, err)
+		_, seen := deck[card.Name]
+		err := deck.AddToSection(card, e.Count, e.Section)
+		if err == nil && !seen {
+			deck[card.Name].Printing = e.Set != ""
 		}
-	}
-	logrus.Infof("Took %v seconds to process %d lines", time.Now().Sub(t), linecount)
+		return err
+	})
 	return deck, errs
 }
 
@@ -58,22 +81,46 @@ func subtractDeck(newDeck, collection DeckList) DeckList {
 		if cEntry, ok := collection[name]; ok {
 			newCount := entry.Count - cEntry.Count
 			if newCount > 0 {
-				newList.AddCard(cEntry.Card, newCount)
+				newList.add(entry.Card, newCount)
 			}
 		} else {
-			newList.AddCard(entry.Card, entry.Count)
+			newList.add(entry.Card, entry.Count)
 		}
+		newList.keepPrinting(entry)
 	}
 	return newList
 }
 
 type formatResp struct {
-	Deck DeckList
-	Errs []error
+	Sections []deckSection
+	Deck     DeckList
+	Vendor   vendorList
+	// Cost is the estimated cost of Deck and FullCost of the cards needed
+	// before subtracting the ones already owned.
+	Cost     *CostEstimate
+	FullCost *CostEstimate
+	// Needs lists the decks needing each card when there is more than one
+	Needs map[string][]DeckNeed
+	Errs  []error
+}
+
+// vendorList is the buylist formatted for a vendor's mass entry form
+type vendorList struct {
+	Title     string
+	URL       string
+	MassEntry string
 }
 
+// deckSection is a named part of a deck list for the buylist template
+type deckSection struct {
+	Name  string
+	Cards DeckList
+}
+
+// formatBuyList shows the cards to buy for one or more decks, see
+// buyListDecks, less the cards already owned.  The share form value picks
+// how the cards needed by several decks are combined, see MultiBuyList.
 func (a *APIServer) formatBuyList(c echo.Context) error {
-	cardlist := c.FormValue("cardlist")
 	subtractcards := c.FormValue("subtractlist")
 
 	excludebasic := false
@@ -81,20 +128,17 @@ func (a *APIServer) formatBuyList(c echo.Context) error {
 		excludebasic = true
 	}
 
-	var cardreader io.Reader
-	if cardlist == "" {
-		cardfile, err := c.FormFile("cardlistfile")
-		if err != nil {
-			return fmt.Errorf("No form or file input given: %s", err)
-		}
-		src, err := cardfile.Open()
-		if err != nil {
-			return fmt.Errorf("Error opening form file: %s", err)
-		}
-		defer src.Close()
-		cardreader = src
-	} else {
-		cardreader = strings.NewReader(cardlist)
+	share := c.FormValue("share")
+	if share == "" {
+		share = shareSleeved
+	}
+	if share != shareSleeved && share != shareShared {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid share: '%s', expected %s or %s", share, shareSleeved, shareShared))
+	}
+
+	decks, cardsErrs, err := a.buyListDecks(c, excludebasic)
+	if err != nil {
+		return err
 	}
 
 	var subtractreader io.Reader
@@ -107,18 +151,88 @@ func (a *APIServer) formatBuyList(c echo.Context) error {
 				return fmt.Errorf("Error opening form file: %s", err)
 			}
 			defer src.Close()
-			subtractreader = src
+			subtractreader = formFile{File: src, name: cardfile.Filename}
 		}
 	}
-	cards, cardsErrs := readerToDeck(cardreader, excludebasic, a.DBH)
 	subcards, subcardsErrs := readerToDeck(subtractreader, excludebasic, a.DBH)
+	// A stored collection can be subtracted as well as, or instead of, a list
+	if id := c.FormValue("collection"); id != "" {
+		collID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid collection: '%s'", id))
+		}
+		if _, err := db.CollectionByID(a.DBH, collID); err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusBadRequest, "No collection with that id")
+		}
+		owned, err := collectionDeck(a.DBH, collID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		for _, e := range owned {
+			subcards.add(e.Card, e.Count)
+		}
+	}
+
+	limit, err := playsetLimitParams(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	needed, needs := MultiBuyList(decks, limit, share == shareShared)
+	buylist := subtractDeck(needed, subcards)
+
+	// Other formats skip the html page to download the buylist directly
+	if format := c.FormValue("format"); format != "" && format != "html" {
+		w, err := DeckWriterFor(format)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		resp := c.Response()
+		resp.Header().Set(echo.HeaderContentType, w.ContentType())
+		resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"buylist%s\"", w.Extension()))
+		resp.WriteHeader(http.StatusOK)
+		return w.Write(resp, buylist)
+	}
+
+	vendorName := c.FormValue("vendor")
+	if vendorName == "" {
+		vendorName = "tcgplayer"
+	}
+	vendor, err := VendorFor(vendorName)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 
-	buylist := subtractDeck(cards, subcards)
+	cost, err := EstimateCost(a.DBH, buylist, vendor.Name())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	fullCost, err := EstimateCost(a.DBH, needed, vendor.Name())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
 
 	f := formatResp{
-		Deck: buylist,
+		Deck:     buylist,
+		Cost:     cost,
+		FullCost: fullCost,
+		Vendor: vendorList{
+			Title:     vendor.Title(),
+			URL:       vendor.URL(buylist),
+			MassEntry: vendor.MassEntry(buylist),
+		},
 		Errs: append(cardsErrs, subcardsErrs...),
 	}
+	if len(decks) == 1 {
+		cards := decks[0].Cards
+		for _, name := range cards.SectionNames() {
+			f.Sections = append(f.Sections, deckSection{Name: name, Cards: cards.Section(name)})
+		}
+	} else {
+		for _, d := range decks {
+			f.Sections = append(f.Sections, deckSection{Name: d.Name, Cards: d.Cards})
+		}
+		f.Needs = needs
+	}
 	return c.Render(http.StatusOK, "resp", f)
 }
 
@@ -126,6 +240,11 @@ func (a *APIServer) formatBuyList(c echo.Context) error {
 type CardEntry struct {
 	Card  *mtgjson.Card
 	Count int
+	// Sections holds how many of Count are in each deck section
+	Sections map[string]int
+	// Printing is true if the deck list named Card's set, otherwise Card is
+	// just the latest printing of the card.
+	Printing bool
 }
 
 func (c CardEntry) String() string {
@@ -150,27 +269,184 @@ func (d DeckList) String() string {
 	return strings.Join(retStrs, "\n")
 }
 
+// PlaysetLimit caps how many copies of each card go on a buylist
+type PlaysetLimit struct {
+	// Copies is the most of any one card, 0 for no limit
+	Copies int
+	// Decks is how many decks the cards are for, multiplying the limit
+	Decks int
+}
+
+// DefaultPlaysetLimit allows a playset of each card for a single deck
+var DefaultPlaysetLimit = PlaysetLimit{Copies: 4, Decks: 1}
+
+// Max returns how many copies of card are allowed, or -1 for no limit.
+// Basic lands and cards like Relentless Rats are never limited and cards
+// like Seven Dwarves use the number in their rules text.
+func (p PlaysetLimit) Max(card *mtgjson.Card) int {
+	if p.Copies <= 0 {
+		return -1
+	}
+	n := copyLimit(card, p.Copies)
+	if n > 0 && p.Decks > 1 {
+		n *= p.Decks
+	}
+	return n
+}
+
+// playsetLimits are the named limits accepted by playsetLimitParams
+var playsetLimits = map[string]int{
+	"":          4,
+	"playset":   4,
+	"singleton": 1,
+	"none":      0,
+}
+
+// playsetLimitParams reads the limit and decks form values.  limit is
+// playset, singleton, none or a number of copies.
+func playsetLimitParams(c echo.Context) (PlaysetLimit, error) {
+	limit := DefaultPlaysetLimit
+	l := c.FormValue("limit")
+	if n, ok := playsetLimits[l]; ok {
+		limit.Copies = n
+	} else {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return limit, fmt.Errorf("Invalid limit: '%s'", l)
+		}
+		limit.Copies = n
+	}
+	if decks := c.FormValue("decks"); decks != "" {
+		n, err := strconv.Atoi(decks)
+		if err != nil || n < 1 {
+			return limit, fmt.Errorf("Invalid number of decks: '%s'", decks)
+		}
+		limit.Decks = n
+	}
+	return limit, nil
+}
+
 // AddCard adds a card to the deck up to a max of 4 except for basic lands
+// and cards allowing any number of copies, see AddCardLimit.
 func (d DeckList) AddCard(card *mtgjson.Card, count int) error {
+	return d.AddCardLimit(card, count, DefaultPlaysetLimit)
+}
+
+// AddCardLimit adds a card to the deck up to the max allowed by limit
+func (d DeckList) AddCardLimit(card *mtgjson.Card, count int, limit PlaysetLimit) error {
+	err := d.add(card, count)
+	if err != nil {
+		return err
+	}
+	e := d[card.Name]
+	if n := limit.Max(card); n >= 0 && e.Count > n {
+		// The copies over the limit come out of the main deck they were
+		// added to so Section() agrees with Count.
+		e.Sections[sectionMain] -= e.Count - n
+		if e.Sections[sectionMain] <= 0 {
+			delete(e.Sections, sectionMain)
+		}
+		e.Count = n
+	}
+
+	return nil
+}
+
+// add adds count copies of card to the main deck without any playset limit
+func (d DeckList) add(card *mtgjson.Card, count int) error {
+	return d.AddToSection(card, count, sectionMain)
+}
+
+// AddToSection adds count copies of card to the named section without any
+// playset limit.  An empty section is the main deck.
+func (d DeckList) AddToSection(card *mtgjson.Card, count int, section string) error {
 	if card.Name == "" {
 		return fmt.Errorf("Card name can't be empty")
 	}
 	if count < 1 {
 		return fmt.Errorf("Card count must be > 0")
 	}
-	if c, ok := d[card.Name]; ok {
-		c.Count = c.Count + count
-	} else {
-		d[card.Name] = &CardEntry{
-			Card:  card,
-			Count: count,
+	if section == "" {
+		section = sectionMain
+	}
+	c, ok := d[card.Name]
+	if !ok {
+		c = &CardEntry{
+			Card:     card,
+			Sections: map[string]int{},
+		}
+		d[card.Name] = c
+	}
+	c.Count += count
+	c.Sections[section] += count
+	return nil
+}
+
+// SectionNames returns the sections with cards in them in deckSections
+// order, followed by any others alphabetically.
+func (d DeckList) SectionNames() []string {
+	found := map[string]bool{}
+	for _, e := range d {
+		for name, n := range e.Sections {
+			if n > 0 {
+				found[name] = true
+			}
 		}
 	}
-	if d[card.Name].Count > 4 && !card.IsBasicLand() {
-		d[card.Name].Count = 4
+	names := []string{}
+	for _, name := range deckSections {
+		if found[name] {
+			names = append(names, name)
+			delete(found, name)
+		}
+	}
+	others := []string{}
+	for name := range found {
+		others = append(others, name)
 	}
+	sort.Strings(others)
+	return append(names, others...)
+}
 
-	return nil
+// Section returns the cards in one section of the deck
+func (d DeckList) Section(name string) DeckList {
+	section := DeckList{}
+	for _, e := range d {
+		if n := e.Sections[name]; n > 0 {
+			section.AddToSection(e.Card, n, name)
+		}
+	}
+	return section
+}
+
+// BuyList combines every section except the maybe-board into the number of
+// copies of each card to buy, capped by limit.
+func (d DeckList) BuyList(limit PlaysetLimit) DeckList {
+	buy := DeckList{}
+	for _, e := range d {
+		if n := e.Count - e.Sections[sectionMaybe]; n > 0 {
+			buy.AddCardLimit(e.Card, n, limit)
+			buy.keepPrinting(e)
+		}
+	}
+	return buy
+}
+
+// keepPrinting copies whether from named its printing to the entry for the
+// same card in d, if there is one.
+func (d DeckList) keepPrinting(from *CardEntry) {
+	if e, ok := d[from.Card.Name]; ok {
+		e.Printing = from.Printing
+	}
+}
+
+// Total returns the number of cards in the deck
+func (d DeckList) Total() int {
+	total := 0
+	for _, e := range d {
+		total += e.Count
+	}
+	return total
 }
 
 // TCGList formats Deck for TCGPlayer mass input
@@ -186,6 +462,38 @@ func (d DeckList) TCGList() string {
 	return strings.Join(l, "||")
 }
 
+func isSideboardMarker(line string) bool {
+	matched, _ := regexp.MatchString(`(?i)^\[?side`, strings.TrimSpace(line))
+	return matched
+}
+
+var (
+	sectionMarkerRegexp = regexp.MustCompile(`(?i)^\[?(commander|companion|maybe|maybeboard|main|mainboard|deck)\]?:?\s*(\(\d+\))?$`)
+
+	sectionAliases = map[string]string{
+		"commander":  sectionCommander,
+		"companion":  sectionCompanion,
+		"maybe":      sectionMaybe,
+		"maybeboard": sectionMaybe,
+		"main":       sectionMain,
+		"mainboard":  sectionMain,
+		"deck":       sectionMain,
+	}
+)
+
+// sectionMarker returns the section started by header lines like
+// "Sideboard", "[Commander]" or "Maybeboard: (3)".
+func sectionMarker(line string) (string, bool) {
+	if isSideboardMarker(line) {
+		return sectionSideboard, true
+	}
+	m := sectionMarkerRegexp.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return "", false
+	}
+	return sectionAliases[strings.ToLower(m[1])], true
+}
+
 /*
 *
 * Return empty sring for empty lines and common metadata lines
@@ -197,8 +505,7 @@ func parseLine(line string) (string, int, error) {
 	if line == "" {
 		return "", 0, nil
 	}
-	matched, err := regexp.MatchString(`(?i)^\[?side`, line)
-	if matched {
+	if _, ok := sectionMarker(line); ok {
 		return "", 0, nil
 	}
 	parts := strings.SplitN(line, " ", 2)
@@ -216,3 +523,27 @@ func parseLine(line string) (string, int, error) {
 	}
 	return name, count, nil
 }
+
+// formFile is an uploaded file that, like *os.File, knows its name so
+// detectDeckReader can use the extension.
+type formFile struct {
+	multipart.File
+	name string
+}
+
+func (f formFile) Name() string {
+	return f.name
+}
+
+// formFileReader opens an uploaded file from the named form field
+func formFileReader(c echo.Context, field string) (io.ReadCloser, error) {
+	cardfile, err := c.FormFile(field)
+	if err != nil {
+		return nil, fmt.Errorf("No form or file input given: %s", err)
+	}
+	src, err := cardfile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Error opening form file: %s", err)
+	}
+	return formFile{File: src, name: cardfile.Filename}, nil
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/hobeone/mtgbrew/mtgjson"
@@ -47,6 +48,36 @@ func TestTCGList(t *testing.T) {
 	}
 }
 
+func TestPlaysetLimit(t *testing.T) {
+	bolt := &mtgjson.Card{Name: "Lightning Bolt", Text: "Lightning Bolt deals 3 damage to any target."}
+	mountain := &mtgjson.Card{Name: "Mountain", Rarity: "Basic Land"}
+	rats := &mtgjson.Card{Name: "Relentless Rats", Text: "A deck can have any number of cards named Relentless Rats."}
+	dwarves := &mtgjson.Card{Name: "Seven Dwarves", Text: "A deck can have up to seven cards named Seven Dwarves."}
+
+	tests := []struct {
+		limit    PlaysetLimit
+		card     *mtgjson.Card
+		expected int
+	}{
+		{DefaultPlaysetLimit, bolt, 4},
+		{DefaultPlaysetLimit, mountain, 30},
+		{DefaultPlaysetLimit, rats, 30},
+		{DefaultPlaysetLimit, dwarves, 7},
+		{PlaysetLimit{Copies: 1, Decks: 1}, bolt, 1},
+		{PlaysetLimit{Copies: 1, Decks: 1}, rats, 30},
+		{PlaysetLimit{Copies: 4, Decks: 3}, bolt, 12},
+		{PlaysetLimit{Copies: 4, Decks: 3}, dwarves, 21},
+		{PlaysetLimit{Copies: 0, Decks: 1}, bolt, 30},
+	}
+	for _, test := range tests {
+		d := DeckList{}
+		d.AddCardLimit(test.card, 30, test.limit)
+		if d[test.card.Name].Count != test.expected {
+			t.Errorf("%+v: expected %d %s got %d", test.limit, test.expected, test.card.Name, d[test.card.Name].Count)
+		}
+	}
+}
+
 type parseResp struct {
 	Name  string
 	Count int
@@ -76,3 +107,103 @@ func TestParseLine(t *testing.T) {
 		}
 	}
 }
+
+func TestDeckListSections(t *testing.T) {
+	bolt := &mtgjson.Card{Name: "Lightning Bolt"}
+	krenko := &mtgjson.Card{Name: "Krenko, Mob Boss"}
+	mountain := &mtgjson.Card{Name: "Mountain", Rarity: "Basic Land"}
+
+	d := DeckList{}
+	d.AddToSection(krenko, 1, sectionCommander)
+	d.AddToSection(bolt, 3, sectionMain)
+	d.AddToSection(mountain, 20, "")
+	d.AddToSection(bolt, 2, sectionSideboard)
+	d.AddToSection(bolt, 1, sectionMaybe)
+
+	expected := []string{sectionCommander, sectionMain, sectionSideboard, sectionMaybe}
+	if names := d.SectionNames(); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Expected sections %v got %v", expected, names)
+	}
+	if side := d.Section(sectionSideboard); len(side) != 1 || side["Lightning Bolt"].Count != 2 {
+		t.Fatalf("Expected 2 Lightning Bolt in the sideboard got %v", side)
+	}
+	if d["Lightning Bolt"].Count != 6 {
+		t.Fatalf("Expected 6 Lightning Bolt in total got %d", d["Lightning Bolt"].Count)
+	}
+
+	buy := d.BuyList(DefaultPlaysetLimit)
+	if buy["Lightning Bolt"].Count != 4 || buy["Mountain"].Count != 20 || buy["Krenko, Mob Boss"].Count != 1 {
+		t.Fatalf("Expected combined playset limited counts got\n%s", buy)
+	}
+
+	d = DeckList{}
+	d.AddToSection(bolt, 1, sectionMaybe)
+	if buy := d.BuyList(DefaultPlaysetLimit); len(buy) != 0 {
+		t.Fatalf("Expected maybe-board cards not to be bought got %s", buy)
+	}
+}
+
+func TestSectionMarker(t *testing.T) {
+	tests := map[string]string{
+		"Sideboard":            sectionSideboard,
+		"[sideboard]":          sectionSideboard,
+		"Commander":            sectionCommander,
+		"[COMMANDER]":          sectionCommander,
+		"Companion:":           sectionCompanion,
+		"Maybeboard (3)":       sectionMaybe,
+		"Deck":                 sectionMain,
+		"1 Commander's Sphere": "",
+		"4 Lightning Bolt":     "",
+	}
+	for line, expected := range tests {
+		section, ok := sectionMarker(line)
+		if section != expected || ok != (expected != "") {
+			t.Errorf("Expected '%s' to start section '%s' got '%s'", line, expected, section)
+		}
+	}
+}
+
+func TestMultiBuyList(t *testing.T) {
+	bolt := &mtgjson.Card{Name: "Lightning Bolt"}
+	guide := &mtgjson.Card{Name: "Goblin Guide"}
+	mountain := &mtgjson.Card{Name: "Mountain", Rarity: "Basic Land"}
+
+	burn := DeckList{}
+	burn.AddToSection(bolt, 4, sectionMain)
+	burn.AddToSection(guide, 4, sectionMain)
+	burn.AddToSection(mountain, 20, sectionMain)
+	prowess := DeckList{}
+	prowess.AddToSection(bolt, 3, sectionMain)
+	prowess.AddToSection(bolt, 1, sectionSideboard)
+	prowess.AddToSection(mountain, 18, sectionMain)
+	decks := []NamedDeck{{Name: "Burn", Cards: burn}, {Name: "Prowess", Cards: prowess}}
+
+	sleeved, needs := MultiBuyList(decks, DefaultPlaysetLimit, false)
+	if sleeved["Lightning Bolt"].Count != 8 || sleeved["Mountain"].Count != 38 || sleeved["Goblin Guide"].Count != 4 {
+		t.Fatalf("Expected a playset per deck got\n%s", sleeved)
+	}
+	expected := []DeckNeed{{Deck: "Burn", Count: 4}, {Deck: "Prowess", Count: 4}}
+	if !reflect.DeepEqual(needs["Lightning Bolt"], expected) {
+		t.Fatalf("Expected needs %v got %v", expected, needs["Lightning Bolt"])
+	}
+	if len(needs["Goblin Guide"]) != 1 {
+		t.Fatalf("Expected only Burn to need Goblin Guide got %v", needs["Goblin Guide"])
+	}
+
+	shared, _ := MultiBuyList(decks, DefaultPlaysetLimit, true)
+	if shared["Lightning Bolt"].Count != 4 || shared["Mountain"].Count != 20 {
+		t.Fatalf("Expected the most any one deck needs got\n%s", shared)
+	}
+
+	// Buying for several copies of a list only applies to a single list
+	burn.AddToSection(bolt, 8, sectionMain)
+	three := PlaysetLimit{Copies: 4, Decks: 3}
+	sleeved, _ = MultiBuyList(decks, three, false)
+	if sleeved["Lightning Bolt"].Count != 8 {
+		t.Fatalf("Expected a playset per named deck got\n%s", sleeved)
+	}
+	single, _ := MultiBuyList(decks[:1], three, false)
+	if single["Lightning Bolt"].Count != 12 {
+		t.Fatalf("Expected three playsets for one list got\n%s", single)
+	}
+}
@@ -0,0 +1,434 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+	"github.com/labstack/echo"
+)
+
+// collectionResp is a collection along with its cards
+type collectionResp struct {
+	db.Collection
+	Cards []db.CollectionCard `json:"cards"`
+}
+
+// importResp reports how a CSV import went
+type importResp struct {
+	Added  int      `json:"added"`
+	Errors []string `json:"errors"`
+}
+
+// collectionColumns are the columns written by writeCollectionCSV
+var collectionColumns = []string{"quantity", "name", "set", "number", "foil", "condition", "language"}
+
+// collectionColumnAliases maps the headers used by other collection
+// trackers to collectionColumns.
+var collectionColumnAliases = map[string]string{
+	"count":            "quantity",
+	"qty":              "quantity",
+	"card":             "name",
+	"card name":        "name",
+	"set code":         "set",
+	"setcode":          "set",
+	"collector number": "number",
+	"card number":      "number",
+	"finish":           "foil",
+}
+
+// conditionAliases maps spelled out conditions to db.Conditions
+var conditionAliases = map[string]string{
+	"near mint":         "NM",
+	"mint":              "NM",
+	"m":                 "NM",
+	"excellent":         "LP",
+	"lightly played":    "LP",
+	"slightly played":   "LP",
+	"sp":                "LP",
+	"moderately played": "MP",
+	"played":            "MP",
+	"heavily played":    "HP",
+	"damaged":           "DMG",
+	"poor":              "DMG",
+}
+
+func parseCondition(s string) string {
+	s = strings.TrimSpace(s)
+	if cond, ok := conditionAliases[strings.ToLower(s)]; ok {
+		return cond
+	}
+	return strings.ToUpper(s)
+}
+
+func parseFoil(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "yes", "1", "foil", "etched":
+		return true
+	}
+	return false
+}
+
+// lookupPrinting finds the named card, or the given printing of it if set
+// isn't empty.
+func lookupPrinting(dbh *db.Handle, name, set, number string) (*mtgjson.Card, error) {
+	card, err := db.ResolveCard(dbh, name)
+	if err != nil || set == "" {
+		return card, err
+	}
+	printing, err := db.CardPrinting(dbh, card.Name, set, number)
+	if err == sql.ErrNoRows {
+		if number != "" {
+			return nil, fmt.Errorf("No printing of '%s' in set '%s' numbered '%s'", card.Name, set, number)
+		}
+		return nil, fmt.Errorf("No printing of '%s' in set '%s'", card.Name, set)
+	}
+	return printing, err
+}
+
+// readCollectionCSV reads a CSV file with a header row naming its columns,
+// see collectionColumns.  Only the name column is required.  Rows that
+// can't be read are reported as errors and skipped.
+func readCollectionCSV(dbh *db.Handle, r io.Reader) ([]db.CollectionCard, []error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, []error{fmt.Errorf("Error reading CSV header: %s", err)}
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if alias, ok := collectionColumnAliases[h]; ok {
+			h = alias
+		}
+		cols[h] = i
+	}
+	if _, ok := cols["name"]; !ok {
+		return nil, []error{fmt.Errorf("CSV has no name column")}
+	}
+
+	cards := []db.CollectionCard{}
+	errs := []error{}
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		if field("name") == "" {
+			continue
+		}
+		quantity := 1
+		if q := field("quantity"); q != "" {
+			quantity, err = strconv.Atoi(q)
+			if err != nil || quantity < 1 {
+				errs = append(errs, fmt.Errorf("Line %d: Invalid quantity: '%s'", line, q))
+				continue
+			}
+		}
+		condition := parseCondition(field("condition"))
+		if condition != "" && !db.ValidCondition(condition) {
+			errs = append(errs, fmt.Errorf("Line %d: Unknown condition '%s', expected one of %s", line, field("condition"), strings.Join(db.Conditions, ", ")))
+			continue
+		}
+		card, err := lookupPrinting(dbh, field("name"), field("set"), field("number"))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Line %d: %s", line, err))
+			continue
+		}
+		cards = append(cards, db.CollectionCard{
+			MTGJsonID: card.MTGJsonID,
+			Name:      card.Name,
+			Quantity:  quantity,
+			Foil:      parseFoil(field("foil")),
+			Condition: condition,
+			Language:  field("language"),
+		})
+	}
+	return cards, errs
+}
+
+// writeCollectionCSV writes cards in the format read by readCollectionCSV
+func writeCollectionCSV(w io.Writer, cards []db.CollectionCard) error {
+	cw := csv.NewWriter(w)
+	cw.Write(collectionColumns)
+	for _, c := range cards {
+		cw.Write([]string{
+			strconv.Itoa(c.Quantity),
+			c.Name,
+			c.SetCode,
+			c.Number,
+			strconv.FormatBool(c.Foil),
+			c.Condition,
+			c.Language,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// collectionDeck returns the cards in a collection by name, with every
+// printing of a card counted together.
+func collectionDeck(dbh *db.Handle, id int64) (DeckList, error) {
+	cards, err := db.CollectionCards(dbh, id)
+	if err != nil {
+		return nil, err
+	}
+	deck := DeckList{}
+	for _, c := range cards {
+		err = deck.add(&mtgjson.Card{MTGJsonID: c.MTGJsonID, Name: c.Name, SetCode: c.SetCode, Number: c.Number}, c.Quantity)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return deck, nil
+}
+
+// idParam parses a numeric id from the named path parameter
+func idParam(c echo.Context, name string) (int64, error) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Invalid %s: '%s'", name, c.Param(name)))
+	}
+	return id, nil
+}
+
+// collectionParam returns the collection named by the :id path parameter
+func (a *APIServer) collectionParam(c echo.Context) (*db.Collection, error) {
+	id, err := idParam(c, "id")
+	if err != nil {
+		return nil, err
+	}
+	coll, err := db.CollectionByID(a.DBH, id)
+	if err == sql.ErrNoRows {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "No collection with that id")
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return coll, nil
+}
+
+func writeJSON(c echo.Context, status int, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSONBlob(status, b)
+}
+
+func (a *APIServer) listCollections(c echo.Context) error {
+	colls, err := db.Collections(a.DBH)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return writeJSON(c, http.StatusOK, colls)
+}
+
+func (a *APIServer) createCollection(c echo.Context) error {
+	coll, err := db.CreateCollection(a.DBH, c.FormValue("name"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return writeJSON(c, http.StatusCreated, coll)
+}
+
+func (a *APIServer) getCollection(c echo.Context) error {
+	coll, err := a.collectionParam(c)
+	if err != nil {
+		return err
+	}
+	cards, err := db.CollectionCards(a.DBH, coll.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return writeJSON(c, http.StatusOK, collectionResp{Collection: *coll, Cards: cards})
+}
+
+func (a *APIServer) renameCollection(c echo.Context) error {
+	coll, err := a.collectionParam(c)
+	if err != nil {
+		return err
+	}
+	err = db.RenameCollection(a.DBH, coll.ID, c.FormValue("name"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	coll.Name = strings.TrimSpace(c.FormValue("name"))
+	return writeJSON(c, http.StatusOK, coll)
+}
+
+func (a *APIServer) deleteCollection(c echo.Context) error {
+	coll, err := a.collectionParam(c)
+	if err != nil {
+		return err
+	}
+	err = db.DeleteCollection(a.DBH, coll.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// addCollectionCard adds the card given by the name, set and number form
+// values.  quantity defaults to 1.
+func (a *APIServer) addCollectionCard(c echo.Context) error {
+	coll, err := a.collectionParam(c)
+	if err != nil {
+		return err
+	}
+	quantity := 1
+	if q := c.FormValue("quantity"); q != "" {
+		quantity, err = strconv.Atoi(q)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid quantity: '%s'", q))
+		}
+	}
+	card, err := lookupPrinting(a.DBH, c.FormValue("name"), c.FormValue("set"), c.FormValue("number"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	err = db.AddCollectionCards(a.DBH, coll.ID, []db.CollectionCard{{
+		MTGJsonID: card.MTGJsonID,
+		Name:      card.Name,
+		Quantity:  quantity,
+		Foil:      parseFoil(c.FormValue("foil")),
+		Condition: parseCondition(c.FormValue("condition")),
+		Language:  c.FormValue("language"),
+	}})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return a.getCollection(c)
+}
+
+// updateCollectionCard changes the quantity, foil, condition or language
+// of an entry.  Form values that aren't given are left alone.
+func (a *APIServer) updateCollectionCard(c echo.Context) error {
+	coll, err := a.collectionParam(c)
+	if err != nil {
+		return err
+	}
+	id, err := idParam(c, "card")
+	if err != nil {
+		return err
+	}
+	card, err := db.CollectionCardByID(a.DBH, coll.ID, id)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "No card with that id in the collection")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if q := c.FormValue("quantity"); q != "" {
+		card.Quantity, err = strconv.Atoi(q)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid quantity: '%s'", q))
+		}
+	}
+	if f := c.FormValue("foil"); f != "" {
+		card.Foil = parseFoil(f)
+	}
+	if cond := c.FormValue("condition"); cond != "" {
+		card.Condition = parseCondition(cond)
+	}
+	if lang := c.FormValue("language"); lang != "" {
+		card.Language = lang
+	}
+	// Matching another entry merges into it
+	id, err = db.UpdateCollectionCard(a.DBH, *card)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	card, err = db.CollectionCardByID(a.DBH, coll.ID, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return writeJSON(c, http.StatusOK, card)
+}
+
+func (a *APIServer) deleteCollectionCard(c echo.Context) error {
+	coll, err := a.collectionParam(c)
+	if err != nil {
+		return err
+	}
+	id, err := idParam(c, "card")
+	if err != nil {
+		return err
+	}
+	err = db.DeleteCollectionCard(a.DBH, coll.ID, id)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "No card with that id in the collection")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *APIServer) exportCollection(c echo.Context) error {
+	coll, err := a.collectionParam(c)
+	if err != nil {
+		return err
+	}
+	cards, err := db.CollectionCards(a.DBH, coll.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"collection-%d.csv\"", coll.ID))
+	resp.WriteHeader(http.StatusOK)
+	return writeCollectionCSV(resp, cards)
+}
+
+// importCollection adds the cards from a CSV given in the csv form value or
+// uploaded as csvfile, see readCollectionCSV.
+func (a *APIServer) importCollection(c echo.Context) error {
+	coll, err := a.collectionParam(c)
+	if err != nil {
+		return err
+	}
+	var r io.Reader
+	if text := c.FormValue("csv"); text != "" {
+		r = strings.NewReader(text)
+	} else {
+		src, err := formFileReader(c, "csvfile")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		defer src.Close()
+		r = src
+	}
+	cards, errs := readCollectionCSV(a.DBH, r)
+	err = db.AddCollectionCards(a.DBH, coll.ID, cards)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	resp := importResp{Errors: []string{}}
+	for _, card := range cards {
+		resp.Added += card.Quantity
+	}
+	for _, e := range errs {
+		resp.Errors = append(resp.Errors, e.Error())
+	}
+	return writeJSON(c, http.StatusOK, resp)
+}
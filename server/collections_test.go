@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+func TestCollectionCSV(t *testing.T) {
+	dbh := db.MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	sets := map[string]mtgjson.Set{
+		"LEA": {Code: "LEA", Cards: []*mtgjson.Card{
+			{MTGJsonID: "1", SetCode: "LEA", Name: "Shivan Dragon", Number: "174"},
+			{MTGJsonID: "2", SetCode: "LEA", Name: "Lightning Bolt", Number: "161"},
+		}},
+		"M10": {Code: "M10", Cards: []*mtgjson.Card{
+			{MTGJsonID: "3", SetCode: "M10", Name: "Shivan Dragon", Number: "158"},
+		}},
+	}
+	err := db.SaveCards(dbh, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Headers in another tracker's style, in any order
+	in := `Count,Card Name,Set Code,Foil,Condition
+2,Shivan Dragon,LEA,,Near Mint
+1,shivan dragon,m10,foil,LP
+x,Lightning Bolt,LEA,,
+1,Lightning Bolt,M10,,
+4,Lightning Bolt,,,
+1,Lightning Bolt,,,Good
+`
+	cards, errs := readCollectionCSV(dbh, strings.NewReader(in))
+	if len(errs) != 3 {
+		t.Fatalf("Expected errors for the bad quantity, missing printing and unknown condition got %v", errs)
+	}
+	if !strings.Contains(errs[2].Error(), "Line 7: Unknown condition 'Good'") {
+		t.Fatalf("Expected unknown condition error for line 7 got %v", errs[2])
+	}
+	if len(cards) != 3 {
+		t.Fatalf("Expected 3 cards got %+v", cards)
+	}
+
+	coll, err := db.CreateCollection(dbh, "Binder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AddCollectionCards(dbh, coll.ID, cards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.CollectionCards(dbh, coll.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	err = writeCollectionCSV(out, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `quantity,name,set,number,foil,condition,language
+4,Lightning Bolt,LEA,161,false,NM,English
+2,Shivan Dragon,LEA,174,false,NM,English
+1,Shivan Dragon,M10,158,true,LP,English
+`
+	if out.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, out.String())
+	}
+
+	// Exports read back in to the same printings
+	again, errs := readCollectionCSV(dbh, strings.NewReader(out.String()))
+	if len(errs) != 0 || len(again) != len(stored) {
+		t.Fatalf("Expected export to read back cleanly got %+v %v", again, errs)
+	}
+	for i, c := range again {
+		if c.MTGJsonID != stored[i].MTGJsonID || c.Foil != stored[i].Foil || c.Condition != stored[i].Condition {
+			t.Errorf("Expected %+v got %+v", stored[i], c)
+		}
+	}
+
+	owned, err := collectionDeck(dbh, coll.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owned["Shivan Dragon"].Count != 3 || owned["Lightning Bolt"].Count != 4 {
+		t.Fatalf("Expected printings to be counted together got\n%s", owned)
+	}
+}
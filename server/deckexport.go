@@ -0,0 +1,314 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+// DeckWriter writes a DeckList in a particular deck file format
+type DeckWriter interface {
+	// Format is the short name of the format, e.g. "mtgo"
+	Format() string
+	// ContentType and Extension describe the files written
+	ContentType() string
+	Extension() string
+	Write(w io.Writer, d DeckList) error
+}
+
+var deckWriters = []DeckWriter{
+	textWriter{},
+	arenaWriter{},
+	mtgoWriter{},
+	cockatriceWriter{},
+	forgeWriter{},
+	csvWriter{},
+	jsonWriter{},
+}
+
+// DeckWriterFor returns the DeckWriter for the named format
+func DeckWriterFor(format string) (DeckWriter, error) {
+	for _, dw := range deckWriters {
+		if dw.Format() == format {
+			return dw, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown deck format to write: '%s'", format)
+}
+
+// ReadDeck reads a deck list in the named format, or detects the format if
+// format is empty, keeping the section of each card.
+func ReadDeck(dbh *db.Handle, r io.Reader, format string) (DeckList, []error) {
+	var reader DeckReader
+	if format == "" {
+		reader, r = detectDeckReader(r)
+	} else {
+		var err error
+		reader, err = deckReaderFor(format)
+		if err != nil {
+			return nil, []error{err}
+		}
+	}
+	deck := DeckList{}
+	errs := readDeckAs(reader, r, dbh, func(card *mtgjson.Card, e DeckEntry) error {
+		return deck.AddToSection(card, e.Count, e.Section)
+	})
+	return deck, errs
+}
+
+// sectionTitles are the headers written for each section by text formats
+var sectionTitles = map[string]string{
+	sectionMain:      "Deck",
+	sectionSideboard: "Sideboard",
+	sectionCommander: "Commander",
+	sectionCompanion: "Companion",
+	sectionMaybe:     "Maybeboard",
+}
+
+// sorted returns the entries of d ordered by card name
+func (d DeckList) sorted() []*CardEntry {
+	entries := make([]*CardEntry, 0, len(d))
+	for _, e := range d {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Card.Name < entries[j].Card.Name
+	})
+	return entries
+}
+
+// textWriter writes "4 Lightning Bolt" lines with a header line before each
+// section other than a leading main deck.
+type textWriter struct{}
+
+func (textWriter) Format() string      { return "text" }
+func (textWriter) ContentType() string { return "text/plain; charset=utf-8" }
+func (textWriter) Extension() string   { return ".txt" }
+
+func (textWriter) Write(w io.Writer, d DeckList) error {
+	bw := bufio.NewWriter(w)
+	for i, section := range d.SectionNames() {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		if i > 0 || section != sectionMain {
+			fmt.Fprintln(bw, sectionTitles[section])
+		}
+		for _, e := range d.Section(section).sorted() {
+			fmt.Fprintf(bw, "%d %s\n", e.Count, e.Card.Name)
+		}
+	}
+	return bw.Flush()
+}
+
+// arenaWriter writes MTG Arena imports, naming the printing when known.
+// Arena has no maybe-board so it is left out.
+type arenaWriter struct{}
+
+func (arenaWriter) Format() string      { return "arena" }
+func (arenaWriter) ContentType() string { return "text/plain; charset=utf-8" }
+func (arenaWriter) Extension() string   { return ".txt" }
+
+func (arenaWriter) Write(w io.Writer, d DeckList) error {
+	bw := bufio.NewWriter(w)
+	first := true
+	for _, section := range d.SectionNames() {
+		if section == sectionMaybe {
+			continue
+		}
+		if !first {
+			fmt.Fprintln(bw)
+		}
+		first = false
+		fmt.Fprintln(bw, sectionTitles[section])
+		for _, e := range d.Section(section).sorted() {
+			if e.Card.SetCode != "" && e.Card.Number != "" {
+				fmt.Fprintf(bw, "%d %s (%s) %s\n", e.Count, e.Card.Name, strings.ToUpper(e.Card.SetCode), e.Card.Number)
+			} else {
+				fmt.Fprintf(bw, "%d %s\n", e.Count, e.Card.Name)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// writeXML writes v as an indented XML document
+func writeXML(w io.Writer, v interface{}) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(v)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// mtgoWriter writes MTGO .dek files.  MTGO has no command zone so
+// commanders and companions go in the sideboard.
+type mtgoWriter struct{}
+
+func (mtgoWriter) Format() string      { return "mtgo" }
+func (mtgoWriter) ContentType() string { return "application/xml" }
+func (mtgoWriter) Extension() string   { return ".dek" }
+
+func (mtgoWriter) Write(w io.Writer, d DeckList) error {
+	deck := mtgoDeck{}
+	for _, section := range d.SectionNames() {
+		if section == sectionMaybe {
+			continue
+		}
+		for _, e := range d.Section(section).sorted() {
+			deck.Cards = append(deck.Cards, mtgoCard{
+				Quantity:  e.Count,
+				Sideboard: section != sectionMain,
+				Name:      e.Card.Name,
+			})
+		}
+	}
+	return writeXML(w, deck)
+}
+
+// cockatriceWriter writes Cockatrice .cod files, with commanders and
+// companions in the sideboard zone.
+type cockatriceWriter struct{}
+
+func (cockatriceWriter) Format() string      { return "cockatrice" }
+func (cockatriceWriter) ContentType() string { return "application/xml" }
+func (cockatriceWriter) Extension() string   { return ".cod" }
+
+func (cockatriceWriter) Write(w io.Writer, d DeckList) error {
+	main := cockatriceZone{Name: "main"}
+	side := cockatriceZone{Name: "side"}
+	for _, section := range d.SectionNames() {
+		if section == sectionMaybe {
+			continue
+		}
+		zone := &side
+		if section == sectionMain {
+			zone = &main
+		}
+		for _, e := range d.Section(section).sorted() {
+			zone.Cards = append(zone.Cards, cockatriceCard{Number: e.Count, Name: e.Card.Name})
+		}
+	}
+	deck := cockatriceDeck{Version: "1", Zones: []cockatriceZone{main}}
+	if len(side.Cards) > 0 {
+		deck.Zones = append(deck.Zones, side)
+	}
+	return writeXML(w, deck)
+}
+
+// forgeWriter writes Forge .dck files.  Companions go in the sideboard.
+type forgeWriter struct{}
+
+var forgeSectionTitles = map[string]string{
+	sectionMain:      "[Main]",
+	sectionSideboard: "[Sideboard]",
+	sectionCommander: "[Commander]",
+	sectionCompanion: "[Sideboard]",
+}
+
+func (forgeWriter) Format() string      { return "forge" }
+func (forgeWriter) ContentType() string { return "text/plain; charset=utf-8" }
+func (forgeWriter) Extension() string   { return ".dck" }
+
+func (forgeWriter) Write(w io.Writer, d DeckList) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "[metadata]")
+	fmt.Fprintln(bw, "Name=mtgbrew")
+	titles := []string{}
+	cards := map[string][]*CardEntry{}
+	for _, section := range d.SectionNames() {
+		title, ok := forgeSectionTitles[section]
+		if !ok {
+			continue
+		}
+		if _, seen := cards[title]; !seen {
+			titles = append(titles, title)
+		}
+		cards[title] = append(cards[title], d.Section(section).sorted()...)
+	}
+	for _, title := range titles {
+		fmt.Fprintln(bw, title)
+		for _, e := range cards[title] {
+			if e.Card.SetCode != "" {
+				fmt.Fprintf(bw, "%d %s|%s\n", e.Count, e.Card.Name, strings.ToUpper(e.Card.SetCode))
+			} else {
+				fmt.Fprintf(bw, "%d %s\n", e.Count, e.Card.Name)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// csvWriter writes one row per card and section
+type csvWriter struct{}
+
+func (csvWriter) Format() string      { return "csv" }
+func (csvWriter) ContentType() string { return "text/csv; charset=utf-8" }
+func (csvWriter) Extension() string   { return ".csv" }
+
+func (csvWriter) Write(w io.Writer, d DeckList) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"section", "count", "name", "set", "number"})
+	for _, section := range d.SectionNames() {
+		for _, e := range d.Section(section).sorted() {
+			cw.Write([]string{section, strconv.Itoa(e.Count), e.Card.Name, e.Card.SetCode, e.Card.Number})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonWriter writes an object of section names to lists of cards
+type jsonWriter struct{}
+
+type jsonDeckEntry struct {
+	Count  int    `json:"count"`
+	Name   string `json:"name"`
+	Set    string `json:"set,omitempty"`
+	Number string `json:"number,omitempty"`
+}
+
+func (jsonWriter) Format() string      { return "json" }
+func (jsonWriter) ContentType() string { return "application/json; charset=utf-8" }
+func (jsonWriter) Extension() string   { return ".json" }
+
+// jsonSections returns the cards in each section of d sorted by name
+func jsonSections(d DeckList) map[string][]jsonDeckEntry {
+	sections := map[string][]jsonDeckEntry{}
+	for _, section := range d.SectionNames() {
+		for _, e := range d.Section(section).sorted() {
+			sections[section] = append(sections[section], jsonDeckEntry{
+				Count:  e.Count,
+				Name:   e.Card.Name,
+				Set:    e.Card.SetCode,
+				Number: e.Card.Number,
+			})
+		}
+	}
+	return sections
+}
+
+func (jsonWriter) Write(w io.Writer, d DeckList) error {
+	b, err := json.MarshalIndent(jsonSections(d), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
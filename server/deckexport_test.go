@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+func exportTestDeck() DeckList {
+	d := DeckList{}
+	d.AddToSection(&mtgjson.Card{Name: "Krenko, Mob Boss", SetCode: "ddt", Number: "52"}, 1, sectionCommander)
+	d.AddToSection(&mtgjson.Card{Name: "Lightning Bolt", SetCode: "sta", Number: "42"}, 4, sectionMain)
+	d.AddToSection(&mtgjson.Card{Name: "Mountain"}, 20, sectionMain)
+	d.AddToSection(&mtgjson.Card{Name: "Smash to Smithereens", SetCode: "ori", Number: "163"}, 2, sectionSideboard)
+	d.AddToSection(&mtgjson.Card{Name: "Goblin Guide"}, 1, sectionMaybe)
+	return d
+}
+
+func TestWriteTextFormats(t *testing.T) {
+	tests := map[string]string{
+		"text": `Commander
+1 Krenko, Mob Boss
+
+Deck
+4 Lightning Bolt
+20 Mountain
+
+Sideboard
+2 Smash to Smithereens
+
+Maybeboard
+1 Goblin Guide
+`,
+		"arena": `Commander
+1 Krenko, Mob Boss (DDT) 52
+
+Deck
+4 Lightning Bolt (STA) 42
+20 Mountain
+
+Sideboard
+2 Smash to Smithereens (ORI) 163
+`,
+		"forge": `[metadata]
+Name=mtgbrew
+[Commander]
+1 Krenko, Mob Boss|DDT
+[Main]
+4 Lightning Bolt|STA
+20 Mountain
+[Sideboard]
+2 Smash to Smithereens|ORI
+`,
+		"csv": `section,count,name,set,number
+commander,1,"Krenko, Mob Boss",ddt,52
+main,4,Lightning Bolt,sta,42
+main,20,Mountain,,
+sideboard,2,Smash to Smithereens,ori,163
+maybe,1,Goblin Guide,,
+`,
+	}
+	for format, expected := range tests {
+		w, err := DeckWriterFor(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		err = w.Write(buf, exportTestDeck())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Errorf("Expected %s output:\n%s\ngot:\n%s", format, expected, buf.String())
+		}
+	}
+}
+
+// TestWriteReadRoundTrip checks each format can be read back, allowing for
+// sections the format doesn't have.
+func TestWriteReadRoundTrip(t *testing.T) {
+	tests := []struct {
+		format   string
+		sections map[string]string
+	}{
+		{"text", nil},
+		{"arena", map[string]string{sectionMaybe: ""}},
+		{"forge", map[string]string{sectionMaybe: ""}},
+		{"mtgo", map[string]string{sectionMaybe: "", sectionCommander: sectionSideboard}},
+		{"cockatrice", map[string]string{sectionMaybe: "", sectionCommander: sectionSideboard}},
+	}
+	for _, test := range tests {
+		w, _ := DeckWriterFor(test.format)
+		buf := &bytes.Buffer{}
+		err := w.Write(buf, exportTestDeck())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, rest := detectDeckReader(buf)
+		// Text lists with section headers are read as Arena lists
+		if r.Format() != test.format && test.format != "text" {
+			t.Errorf("Expected %s output to be detected as %s, got %s", test.format, test.format, r.Format())
+		}
+		entries, errs := r.Read(rest)
+		if len(errs) != 0 {
+			t.Errorf("Unexpected errors reading %s: %v", test.format, errs)
+		}
+
+		expected := []string{}
+		d := exportTestDeck()
+		for _, section := range d.SectionNames() {
+			mapped, ok := test.sections[section]
+			if !ok {
+				mapped = section
+			}
+			if mapped == "" {
+				continue
+			}
+			for _, e := range d.Section(section) {
+				expected = append(expected, mapped+" "+e.String())
+			}
+		}
+		found := []string{}
+		for _, e := range entries {
+			found = append(found, e.Section+" "+CardEntry{Card: &mtgjson.Card{Name: e.Name}, Count: e.Count}.String())
+		}
+		sort.Strings(expected)
+		sort.Strings(found)
+		if !reflect.DeepEqual(found, expected) {
+			t.Errorf("Expected %s round trip to give %v got %v", test.format, expected, found)
+		}
+	}
+}
+
+func TestWriteCappedBuyList(t *testing.T) {
+	d := DeckList{}
+	d.AddToSection(&mtgjson.Card{Name: "Lightning Bolt"}, 6, sectionMain)
+	d.AddToSection(&mtgjson.Card{Name: "Lightning Bolt"}, 2, sectionSideboard)
+	d.AddToSection(&mtgjson.Card{Name: "Mountain", Rarity: "Basic Land"}, 20, sectionMain)
+	buy := d.BuyList(DefaultPlaysetLimit)
+
+	w, err := DeckWriterFor("text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	err = w.Write(out, buy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "4 Lightning Bolt\n20 Mountain\n"
+	if out.String() != expected {
+		t.Fatalf("Expected the capped count to be written\n%s\ngot\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,428 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Deck sections, the main deck is the default
+const (
+	sectionMain      = "main"
+	sectionSideboard = "sideboard"
+	sectionCommander = "commander"
+	sectionCompanion = "companion"
+	sectionMaybe     = "maybe"
+)
+
+// deckSections is the order sections are listed in
+var deckSections = []string{sectionCommander, sectionCompanion, sectionMain, sectionSideboard, sectionMaybe}
+
+// DeckEntry is a single card line read from a deck file, before the card is
+// looked up.  Set and Number are only given by formats that name the
+// printing.
+type DeckEntry struct {
+	Name    string
+	Count   int
+	Section string
+	Set     string
+	Number  string
+}
+
+// DeckReader reads a particular deck file format
+type DeckReader interface {
+	// Format is the short name of the format, e.g. "mtgo"
+	Format() string
+	// Detect reports if a file looks like this format given its name, which
+	// may be empty, and the first few hundred bytes of its contents.
+	Detect(filename string, head []byte) bool
+	// Read returns the cards in the deck along with any lines that couldn't
+	// be parsed.
+	Read(r io.Reader) ([]DeckEntry, []error)
+}
+
+// deckReaders are tried in order by detectDeckReader.  Plain text lists
+// are the fallback so aren't included.
+var deckReaders = []DeckReader{
+	mtgoReader{},
+	cockatriceReader{},
+	forgeReader{},
+	arenaReader{},
+}
+
+// detectHeadSize is how much of a file is looked at to detect its format
+const detectHeadSize = 512
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// detectDeckReader picks the DeckReader for r, returning a reader with the
+// same content as r to read from.  If r has a Name method, like *os.File,
+// its extension is used to help detection.
+func detectDeckReader(r io.Reader) (DeckReader, io.Reader) {
+	filename := ""
+	if n, ok := r.(interface {
+		Name() string
+	}); ok {
+		filename = n.Name()
+	}
+	br := bufio.NewReaderSize(r, detectHeadSize)
+	// Windows tools like MTGO like to start files with a byte order mark
+	if bom, _ := br.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+		br.Discard(len(utf8BOM))
+	}
+	head, _ := br.Peek(detectHeadSize)
+	for _, dr := range deckReaders {
+		if dr.Detect(filename, head) {
+			return dr, br
+		}
+	}
+	return textReader{}, br
+}
+
+// deckReaderFor returns the DeckReader for the named format
+func deckReaderFor(format string) (DeckReader, error) {
+	for _, dr := range append(deckReaders, textReader{}) {
+		if dr.Format() == format {
+			return dr, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown deck format to read: '%s'", format)
+}
+
+// hasExtension checks filename against ext, ignoring case
+func hasExtension(filename, ext string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ext)
+}
+
+// xmlRoot returns the name of the root element of an XML document, or ""
+// if head doesn't start with one.
+func xmlRoot(head []byte) string {
+	d := xml.NewDecoder(bytes.NewReader(head))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return ""
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return t.Name.Local
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) > 0 {
+				return ""
+			}
+		}
+	}
+}
+
+// textReader reads "4 Lightning Bolt" style lists split into sections by
+// header lines, see sectionMarker.
+type textReader struct{}
+
+func (textReader) Format() string { return "text" }
+
+func (textReader) Detect(filename string, head []byte) bool {
+	return true
+}
+
+func (textReader) Read(r io.Reader) ([]DeckEntry, []error) {
+	scanner := bufio.NewScanner(r)
+	entries := []DeckEntry{}
+	errs := []error{}
+	section := sectionMain
+	for line := 1; scanner.Scan(); line++ {
+		if s, ok := sectionMarker(scanner.Text()); ok {
+			section = s
+			continue
+		}
+		name, count, err := parseLine(scanner.Text())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		if count < 1 {
+			errs = append(errs, fmt.Errorf("Line %d: Invalid Count: '%d'", line, count))
+			continue
+		}
+		entries = append(entries, DeckEntry{Name: name, Count: count, Section: section})
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return entries, errs
+}
+
+// mtgoReader reads MTGO .dek XML files:
+//
+//	<Deck>
+//	  <Cards CatID="12345" Quantity="4" Sideboard="false" Name="Lightning Bolt" />
+//	</Deck>
+type mtgoReader struct{}
+
+type mtgoDeck struct {
+	XMLName              xml.Name   `xml:"Deck"`
+	NetDeckID            int        `xml:"NetDeckID"`
+	PreconstructedDeckID int        `xml:"PreconstructedDeckID"`
+	Cards                []mtgoCard `xml:"Cards"`
+}
+
+type mtgoCard struct {
+	CatID     string `xml:"CatID,attr,omitempty"`
+	Quantity  int    `xml:"Quantity,attr"`
+	Sideboard bool   `xml:"Sideboard,attr"`
+	Name      string `xml:"Name,attr"`
+}
+
+func (mtgoReader) Format() string { return "mtgo" }
+
+func (mtgoReader) Detect(filename string, head []byte) bool {
+	root := xmlRoot(head)
+	return root == "Deck" || (root != "" && hasExtension(filename, ".dek"))
+}
+
+func (mtgoReader) Read(r io.Reader) ([]DeckEntry, []error) {
+	deck := mtgoDeck{}
+	err := xml.NewDecoder(r).Decode(&deck)
+	if err != nil {
+		return nil, []error{fmt.Errorf("Error reading MTGO deck: %s", err)}
+	}
+	entries := []DeckEntry{}
+	errs := []error{}
+	for _, c := range deck.Cards {
+		if c.Name == "" || c.Quantity < 1 {
+			errs = append(errs, fmt.Errorf("Bad MTGO card entry: CatID %s, '%s' x %d", c.CatID, c.Name, c.Quantity))
+			continue
+		}
+		section := sectionMain
+		if c.Sideboard {
+			section = sectionSideboard
+		}
+		entries = append(entries, DeckEntry{Name: c.Name, Count: c.Quantity, Section: section})
+	}
+	return entries, errs
+}
+
+// cockatriceReader reads Cockatrice .cod XML files:
+//
+//	<cockatrice_deck version="1">
+//	  <zone name="main">
+//	    <card number="4" name="Lightning Bolt"/>
+//	  </zone>
+//	  <zone name="side">...</zone>
+//	</cockatrice_deck>
+type cockatriceReader struct{}
+
+type cockatriceDeck struct {
+	XMLName  xml.Name         `xml:"cockatrice_deck"`
+	Version  string           `xml:"version,attr"`
+	DeckName string           `xml:"deckname"`
+	Comments string           `xml:"comments"`
+	Zones    []cockatriceZone `xml:"zone"`
+}
+
+type cockatriceZone struct {
+	Name  string           `xml:"name,attr"`
+	Cards []cockatriceCard `xml:"card"`
+}
+
+type cockatriceCard struct {
+	Number int    `xml:"number,attr"`
+	Name   string `xml:"name,attr"`
+}
+
+func (cockatriceReader) Format() string { return "cockatrice" }
+
+func (cockatriceReader) Detect(filename string, head []byte) bool {
+	root := xmlRoot(head)
+	return root == "cockatrice_deck" || (root != "" && hasExtension(filename, ".cod"))
+}
+
+func (cockatriceReader) Read(r io.Reader) ([]DeckEntry, []error) {
+	deck := cockatriceDeck{}
+	err := xml.NewDecoder(r).Decode(&deck)
+	if err != nil {
+		return nil, []error{fmt.Errorf("Error reading Cockatrice deck: %s", err)}
+	}
+	entries := []DeckEntry{}
+	errs := []error{}
+	for _, zone := range deck.Zones {
+		section := sectionMain
+		switch zone.Name {
+		case "main":
+		case "side":
+			section = sectionSideboard
+		default:
+			// Tokens and other zones aren't part of the deck
+			continue
+		}
+		for _, c := range zone.Cards {
+			if c.Name == "" || c.Number < 1 {
+				errs = append(errs, fmt.Errorf("Bad Cockatrice card entry: '%s' x %d", c.Name, c.Number))
+				continue
+			}
+			entries = append(entries, DeckEntry{Name: c.Name, Count: c.Number, Section: section})
+		}
+	}
+	return entries, errs
+}
+
+// arenaReader reads MTG Arena exports:
+//
+//	Commander
+//	1 Krenko, Mob Boss (DDT) 52
+//
+//	Deck
+//	4 Lightning Bolt (STA) 42
+//
+// Older exports have no section headers and start the sideboard after a
+// blank line.
+type arenaReader struct{}
+
+var (
+	arenaLineRegexp = regexp.MustCompile(`^(\d+)x?\s+(.+?)(?:\s+\(([A-Za-z0-9_]+)\)(?:\s+(\S+))?)?$`)
+	arenaSetRegexp  = regexp.MustCompile(`\s\([A-Za-z0-9_]+\)\s+\S+$`)
+
+	// arenaSections maps section headers to deck sections, "" for sections
+	// that don't hold cards.
+	arenaSections = map[string]string{
+		"deck":       sectionMain,
+		"sideboard":  sectionSideboard,
+		"commander":  sectionCommander,
+		"companion":  sectionCompanion,
+		"maybeboard": sectionMaybe,
+		"about":      "",
+	}
+)
+
+func (arenaReader) Format() string { return "arena" }
+
+func (arenaReader) Detect(filename string, head []byte) bool {
+	for _, line := range strings.Split(string(head), "\n") {
+		line = strings.TrimSpace(line)
+		if arenaSetRegexp.MatchString(line) {
+			return true
+		}
+		switch strings.ToLower(line) {
+		case "deck", "commander", "companion", "about":
+			return true
+		}
+	}
+	return false
+}
+
+func (arenaReader) Read(r io.Reader) ([]DeckEntry, []error) {
+	scanner := bufio.NewScanner(r)
+	entries := []DeckEntry{}
+	errs := []error{}
+	section := sectionMain
+	sawHeader := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			if !sawHeader && len(entries) > 0 {
+				section = sectionSideboard
+			}
+			continue
+		}
+		if s, ok := arenaSections[strings.ToLower(line)]; ok {
+			section = s
+			sawHeader = true
+			continue
+		}
+		if section == "" {
+			continue
+		}
+		m := arenaLineRegexp.FindStringSubmatch(line)
+		if m == nil {
+			errs = append(errs, fmt.Errorf("Bad line format: '%s'", line))
+			continue
+		}
+		count, err := strconv.Atoi(m[1])
+		if err != nil || count < 1 {
+			errs = append(errs, fmt.Errorf("Invalid Count: '%s'", m[1]))
+			continue
+		}
+		entries = append(entries, DeckEntry{
+			Name:    m[2],
+			Count:   count,
+			Section: section,
+			Set:     m[3],
+			Number:  m[4],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return entries, errs
+}
+
+// forgeReader reads Forge .dck files:
+//
+//	[metadata]
+//	Name=Burn
+//	[Main]
+//	4 Lightning Bolt|STA
+//	[Sideboard]
+//	2 Smash to Smithereens|ORI
+type forgeReader struct{}
+
+var (
+	forgeLineRegexp = regexp.MustCompile(`^(\d+)\s+([^|]+?)\s*(?:\|([^|]*))?(?:\|.*)?$`)
+
+	// forgeSections maps Forge section headers to deck sections, "" for
+	// sections that don't hold cards in the deck.
+	forgeSections = map[string]string{
+		"main":      sectionMain,
+		"sideboard": sectionSideboard,
+		"commander": sectionCommander,
+	}
+)
+
+func (forgeReader) Format() string { return "forge" }
+
+func (forgeReader) Detect(filename string, head []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(head), []byte("[metadata]")) || hasExtension(filename, ".dck")
+}
+
+func (forgeReader) Read(r io.Reader) ([]DeckEntry, []error) {
+	scanner := bufio.NewScanner(r)
+	entries := []DeckEntry{}
+	errs := []error{}
+	section := sectionMain
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = forgeSections[strings.ToLower(strings.Trim(line, "[]"))]
+			continue
+		}
+		if section == "" {
+			continue
+		}
+		m := forgeLineRegexp.FindStringSubmatch(line)
+		if m == nil {
+			errs = append(errs, fmt.Errorf("Bad line format: '%s'", line))
+			continue
+		}
+		count, err := strconv.Atoi(m[1])
+		if err != nil || count < 1 {
+			errs = append(errs, fmt.Errorf("Invalid Count: '%s'", m[1]))
+			continue
+		}
+		entries = append(entries, DeckEntry{Name: m[2], Count: count, Section: section, Set: m[3]})
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return entries, errs
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type namedReader struct {
+	*strings.Reader
+	name string
+}
+
+func (n namedReader) Name() string {
+	return n.name
+}
+
+func TestDetectDeckReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"", "4 Lightning Bolt\n", "text"},
+		{"deck.txt", "4 Lightning Bolt\n", "text"},
+		{"", "\xef\xbb\xbf<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<Deck xmlns:xsd=\"http://www.w3.org/2001/XMLSchema\">", "mtgo"},
+		{"", "<?xml version=\"1.0\"?>\n<cockatrice_deck version=\"1\">", "cockatrice"},
+		{"deck.dek", "<?xml version=\"1.0\"?>\n<Something/>", "mtgo"},
+		{"deck.cod", "<?xml version=\"1.0\"?>\n<Something/>", "cockatrice"},
+		{"deck.dek", "4 Lightning Bolt\n", "text"},
+		{"", "Deck\n4 Lightning Bolt\n", "arena"},
+		{"", "4 Lightning Bolt (STA) 42\n", "arena"},
+	}
+	for _, test := range tests {
+		r, rest := detectDeckReader(namedReader{strings.NewReader(test.content), test.name})
+		if r.Format() != test.expected {
+			t.Errorf("Expected %s (%s) to be %s got %s", test.name, test.content, test.expected, r.Format())
+		}
+		b, _ := ioutil.ReadAll(rest)
+		if !strings.HasSuffix(test.content, string(b)) || len(b) == 0 {
+			t.Errorf("Expected the returned reader to have the file contents, got %s", b)
+		}
+	}
+}
+
+func TestReadMTGODeck(t *testing.T) {
+	dek := `<?xml version="1.0" encoding="utf-8"?>
+<Deck xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <NetDeckID>0</NetDeckID>
+  <PreconstructedDeckID>0</PreconstructedDeckID>
+  <Cards CatID="53879" Quantity="4" Sideboard="false" Name="Lightning Bolt" Annotation="0" />
+  <Cards CatID="49363" Quantity="20" Sideboard="false" Name="Mountain" Annotation="0" />
+  <Cards CatID="62511" Quantity="2" Sideboard="true" Name="Smash to Smithereens" Annotation="0" />
+  <Cards CatID="1" Quantity="0" Sideboard="true" Name="Broken" Annotation="0" />
+</Deck>`
+	entries, errs := mtgoReader{}.Read(strings.NewReader(dek))
+	expected := []DeckEntry{
+		{Name: "Lightning Bolt", Count: 4, Section: sectionMain},
+		{Name: "Mountain", Count: 20, Section: sectionMain},
+		{Name: "Smash to Smithereens", Count: 2, Section: sectionSideboard},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("Expected %v got %v", expected, entries)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected an error for the bad entry got %v", errs)
+	}
+}
+
+func TestReadCockatriceDeck(t *testing.T) {
+	cod := `<?xml version="1.0" encoding="UTF-8"?>
+<cockatrice_deck version="1">
+    <deckname>Burn</deckname>
+    <comments></comments>
+    <zone name="main">
+        <card number="4" name="Lightning Bolt"/>
+        <card number="20" name="Mountain"/>
+    </zone>
+    <zone name="side">
+        <card number="2" name="Smash to Smithereens"/>
+    </zone>
+    <zone name="tokens">
+        <card number="1" name="Goblin"/>
+    </zone>
+</cockatrice_deck>`
+	entries, errs := cockatriceReader{}.Read(strings.NewReader(cod))
+	expected := []DeckEntry{
+		{Name: "Lightning Bolt", Count: 4, Section: sectionMain},
+		{Name: "Mountain", Count: 20, Section: sectionMain},
+		{Name: "Smash to Smithereens", Count: 2, Section: sectionSideboard},
+	}
+	if !reflect.DeepEqual(entries, expected) || len(errs) != 0 {
+		t.Fatalf("Expected %v got %v, %v", expected, entries, errs)
+	}
+}
+
+func TestReadArenaDeck(t *testing.T) {
+	deck := `About
+Name Krenko
+
+Commander
+1 Krenko, Mob Boss (DDT) 52
+
+Deck
+4 Lightning Bolt (STA) 42
+20 Mountain (ZNR) 279
+1 Goblin Guide
+Fire // Ice (APC) 128
+
+Sideboard
+2 Smash to Smithereens (ORI) 163
+`
+	entries, errs := arenaReader{}.Read(strings.NewReader(deck))
+	expected := []DeckEntry{
+		{Name: "Krenko, Mob Boss", Count: 1, Section: sectionCommander, Set: "DDT", Number: "52"},
+		{Name: "Lightning Bolt", Count: 4, Section: sectionMain, Set: "STA", Number: "42"},
+		{Name: "Mountain", Count: 20, Section: sectionMain, Set: "ZNR", Number: "279"},
+		{Name: "Goblin Guide", Count: 1, Section: sectionMain},
+		{Name: "Smash to Smithereens", Count: 2, Section: sectionSideboard, Set: "ORI", Number: "163"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("Expected %v got %v", expected, entries)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected an error for the line without a count got %v", errs)
+	}
+
+	// Without headers a blank line starts the sideboard
+	entries, _ = arenaReader{}.Read(strings.NewReader("4 Lightning Bolt (STA) 42\n\n2 Smash to Smithereens (ORI) 163\n"))
+	if len(entries) != 2 || entries[1].Section != sectionSideboard {
+		t.Fatalf("Expected the second card in the sideboard got %v", entries)
+	}
+}
+
+func TestReadTextDeck(t *testing.T) {
+	deck := "4 Lightning Bolt\n\n[Sideboard]\n0 Smash to Smithereens\n-1 Mountain\n2x Goblin Guide\n"
+	entries, errs := textReader{}.Read(strings.NewReader(deck))
+	expected := []DeckEntry{
+		{Name: "Lightning Bolt", Count: 4, Section: sectionMain},
+		{Name: "Goblin Guide", Count: 2, Section: sectionSideboard},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("Expected %v got %v", expected, entries)
+	}
+	if len(errs) != 2 || errs[0].Error() != "Line 4: Invalid Count: '0'" || errs[1].Error() != "Line 5: Invalid Count: '-1'" {
+		t.Fatalf("Expected errors for the zero and negative counts got %v", errs)
+	}
+}
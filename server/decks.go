@@ -0,0 +1,352 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+	"github.com/labstack/echo"
+)
+
+// DeckChange is a number of copies of a card added to or taken out of a
+// section of a deck.
+type DeckChange struct {
+	Section string `json:"section"`
+	Name    string `json:"name"`
+	Count   int    `json:"count"`
+}
+
+// DeckDiff lists the cards added to (In) and taken out of (Out) a deck
+// between two versions.
+type DeckDiff struct {
+	From int          `json:"from"`
+	To   int          `json:"to"`
+	In   []DeckChange `json:"in"`
+	Out  []DeckChange `json:"out"`
+}
+
+func (d DeckDiff) String() string {
+	lines := []string{fmt.Sprintf("Version %d to %d:", d.From, d.To)}
+	format := func(sign string, c DeckChange) string {
+		if c.Section == sectionMain {
+			return fmt.Sprintf("%s%d %s", sign, c.Count, c.Name)
+		}
+		return fmt.Sprintf("%s%d %s (%s)", sign, c.Count, c.Name, c.Section)
+	}
+	for _, c := range d.In {
+		lines = append(lines, format("+", c))
+	}
+	for _, c := range d.Out {
+		lines = append(lines, format("-", c))
+	}
+	if len(d.In)+len(d.Out) == 0 {
+		lines = append(lines, "No changes")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DiffDecks compares how many copies of each card are in each section of
+// from and to.  Changes are ordered by section and then card name.
+func DiffDecks(from, to DeckList) DeckDiff {
+	all := DeckList{}
+	for _, d := range []DeckList{from, to} {
+		for _, e := range d {
+			for section, n := range e.Sections {
+				all.AddToSection(e.Card, n, section)
+			}
+		}
+	}
+	diff := DeckDiff{In: []DeckChange{}, Out: []DeckChange{}}
+	for _, section := range all.SectionNames() {
+		for _, e := range all.Section(section).sorted() {
+			var before, after int
+			if f, ok := from[e.Card.Name]; ok {
+				before = f.Sections[section]
+			}
+			if t, ok := to[e.Card.Name]; ok {
+				after = t.Sections[section]
+			}
+			switch {
+			case after > before:
+				diff.In = append(diff.In, DeckChange{Section: section, Name: e.Card.Name, Count: after - before})
+			case after < before:
+				diff.Out = append(diff.Out, DeckChange{Section: section, Name: e.Card.Name, Count: before - after})
+			}
+		}
+	}
+	return diff
+}
+
+// deckCards flattens d into a db.DeckCard for each card in each section
+func deckCards(d DeckList) []db.DeckCard {
+	cards := []db.DeckCard{}
+	for _, section := range d.SectionNames() {
+		for _, e := range d.Section(section).sorted() {
+			cards = append(cards, db.DeckCard{
+				Section:   section,
+				MTGJsonID: e.Card.MTGJsonID,
+				Name:      e.Card.Name,
+				Count:     e.Count,
+			})
+		}
+	}
+	return cards
+}
+
+// SaveDeck saves d as a new version of the named deck
+func SaveDeck(dbh *db.Handle, name, note string, d DeckList) (*db.DeckVersion, error) {
+	if len(d) == 0 {
+		return nil, fmt.Errorf("Deck has no cards")
+	}
+	return db.SaveDeck(dbh, name, note, deckCards(d))
+}
+
+// LoadDeckVersion returns a version of a saved deck along with its cards,
+// see db.GetDeckVersion.  Cards whose printing is no longer in the db are
+// looked up by name.
+func LoadDeckVersion(dbh *db.Handle, deckID int64, version int) (*db.DeckVersion, DeckList, error) {
+	v, err := db.GetDeckVersion(dbh, deckID, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	deck := DeckList{}
+	for _, c := range v.Cards {
+		card, err := db.CardByMTGJsonID(dbh, c.MTGJsonID)
+		if err == sql.ErrNoRows {
+			card, err = db.ResolveCard(dbh, c.Name)
+			if _, ok := err.(*db.UnknownCardError); ok {
+				card, err = &mtgjson.Card{MTGJsonID: c.MTGJsonID, Name: c.Name}, nil
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		err = deck.AddToSection(card, c.Count, c.Section)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return v, deck, nil
+}
+
+// deckVersionResp is a version of a saved deck with its cards by section
+type deckVersionResp struct {
+	ID       int64                      `json:"id"`
+	Name     string                     `json:"name"`
+	Version  int                        `json:"version"`
+	Latest   int                        `json:"latest_version"`
+	Note     string                     `json:"note"`
+	Created  time.Time                  `json:"created"`
+	Sections map[string][]jsonDeckEntry `json:"sections"`
+}
+
+func newDeckVersionResp(deck *db.Deck, v *db.DeckVersion, cards DeckList) deckVersionResp {
+	return deckVersionResp{
+		ID:       deck.ID,
+		Name:     deck.Name,
+		Version:  v.Version,
+		Latest:   deck.Version,
+		Note:     v.Note,
+		Created:  v.Created,
+		Sections: jsonSections(cards),
+	}
+}
+
+// deckParam returns the saved deck named by the :id path parameter
+func (a *APIServer) deckParam(c echo.Context) (*db.Deck, error) {
+	id, err := idParam(c, "id")
+	if err != nil {
+		return nil, err
+	}
+	deck, err := db.DeckByID(a.DBH, id)
+	if err == sql.ErrNoRows {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "No deck with that id")
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return deck, nil
+}
+
+// versionValue parses a deck version number, with "" meaning def
+func versionValue(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid version: '%s'", v))
+	}
+	return n, nil
+}
+
+// loadVersion is LoadDeckVersion with errors for the API
+func (a *APIServer) loadVersion(deck *db.Deck, version int) (*db.DeckVersion, DeckList, error) {
+	v, cards, err := LoadDeckVersion(a.DBH, deck.ID, version)
+	if err == sql.ErrNoRows {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Deck has no version %d", version))
+	}
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return v, cards, nil
+}
+
+func (a *APIServer) listDecks(c echo.Context) error {
+	decks, err := db.Decks(a.DBH)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return writeJSON(c, http.StatusOK, decks)
+}
+
+// saveDeck saves the deck in the cardlist form value or cardlistfile upload
+// as a new version of the deck called name.  Decks with cards that can't be
+// read aren't saved.
+func (a *APIServer) saveDeck(c echo.Context) error {
+	var cardreader io.Reader
+	if cardlist := c.FormValue("cardlist"); cardlist != "" {
+		cardreader = strings.NewReader(cardlist)
+	} else {
+		src, err := formFileReader(c, "cardlistfile")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		defer src.Close()
+		cardreader = src
+	}
+	cards, errs := ReadDeck(a.DBH, cardreader, c.FormValue("from"))
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, e := range errs {
+			msgs[i] = e.Error()
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, strings.Join(msgs, "\n"))
+	}
+	v, err := SaveDeck(a.DBH, c.FormValue("name"), c.FormValue("note"), cards)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	deck, err := db.DeckByID(a.DBH, v.DeckID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return writeJSON(c, http.StatusCreated, newDeckVersionResp(deck, v, cards))
+}
+
+// getDeck returns the latest version of a deck, or the one given by the
+// version query parameter, as JSON or in the deck file format named by the
+// format query parameter.
+func (a *APIServer) getDeck(c echo.Context) error {
+	deck, err := a.deckParam(c)
+	if err != nil {
+		return err
+	}
+	version, err := versionValue(c.QueryParam("version"), 0)
+	if err != nil {
+		return err
+	}
+	v, cards, err := a.loadVersion(deck, version)
+	if err != nil {
+		return err
+	}
+	if format := c.QueryParam("format"); format != "" {
+		w, err := DeckWriterFor(format)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		resp := c.Response()
+		resp.Header().Set(echo.HeaderContentType, w.ContentType())
+		resp.WriteHeader(http.StatusOK)
+		return w.Write(resp, cards)
+	}
+	return writeJSON(c, http.StatusOK, newDeckVersionResp(deck, v, cards))
+}
+
+func (a *APIServer) deckVersions(c echo.Context) error {
+	deck, err := a.deckParam(c)
+	if err != nil {
+		return err
+	}
+	versions, err := db.DeckVersions(a.DBH, deck.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return writeJSON(c, http.StatusOK, versions)
+}
+
+// diffDeck compares the from and to versions of a deck, by default the
+// latest version and the one before it.
+func (a *APIServer) diffDeck(c echo.Context) error {
+	deck, err := a.deckParam(c)
+	if err != nil {
+		return err
+	}
+	to, err := versionValue(c.QueryParam("to"), deck.Version)
+	if err != nil {
+		return err
+	}
+	prev := to - 1
+	if prev < 1 {
+		prev = 1
+	}
+	from, err := versionValue(c.QueryParam("from"), prev)
+	if err != nil {
+		return err
+	}
+	_, fromCards, err := a.loadVersion(deck, from)
+	if err != nil {
+		return err
+	}
+	_, toCards, err := a.loadVersion(deck, to)
+	if err != nil {
+		return err
+	}
+	diff := DiffDecks(fromCards, toCards)
+	diff.From = from
+	diff.To = to
+	return writeJSON(c, http.StatusOK, diff)
+}
+
+// restoreDeck saves an old version of a deck as its latest version
+func (a *APIServer) restoreDeck(c echo.Context) error {
+	deck, err := a.deckParam(c)
+	if err != nil {
+		return err
+	}
+	version, err := versionValue(c.Param("version"), 0)
+	if err != nil {
+		return err
+	}
+	v, err := db.RestoreDeckVersion(a.DBH, deck.ID, version)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("Deck has no version %d", version))
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	deck.Version = v.Version
+	_, cards, err := a.loadVersion(deck, v.Version)
+	if err != nil {
+		return err
+	}
+	return writeJSON(c, http.StatusCreated, newDeckVersionResp(deck, v, cards))
+}
+
+func (a *APIServer) deleteDeck(c echo.Context) error {
+	deck, err := a.deckParam(c)
+	if err != nil {
+		return err
+	}
+	err = db.DeleteDeck(a.DBH, deck.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+func TestDiffDecks(t *testing.T) {
+	bolt := &mtgjson.Card{Name: "Lightning Bolt"}
+	mountain := &mtgjson.Card{Name: "Mountain"}
+	smash := &mtgjson.Card{Name: "Smash to Smithereens"}
+
+	from := DeckList{}
+	from.AddToSection(bolt, 4, sectionMain)
+	from.AddToSection(mountain, 20, sectionMain)
+	from.AddToSection(smash, 1, sectionMain)
+
+	to := DeckList{}
+	to.AddToSection(bolt, 4, sectionMain)
+	to.AddToSection(mountain, 18, sectionMain)
+	to.AddToSection(smash, 3, sectionSideboard)
+
+	diff := DiffDecks(from, to)
+	expectedIn := []DeckChange{{Section: sectionSideboard, Name: "Smash to Smithereens", Count: 3}}
+	expectedOut := []DeckChange{
+		{Section: sectionMain, Name: "Mountain", Count: 2},
+		{Section: sectionMain, Name: "Smash to Smithereens", Count: 1},
+	}
+	if !reflect.DeepEqual(diff.In, expectedIn) {
+		t.Errorf("Expected in %v got %v", expectedIn, diff.In)
+	}
+	if !reflect.DeepEqual(diff.Out, expectedOut) {
+		t.Errorf("Expected out %v got %v", expectedOut, diff.Out)
+	}
+	if diff := DiffDecks(to, to); len(diff.In)+len(diff.Out) != 0 {
+		t.Errorf("Expected no changes comparing a deck to itself got %v", diff)
+	}
+}
+
+func TestSaveAndLoadDeck(t *testing.T) {
+	dbh := db.MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	sets := map[string]mtgjson.Set{
+		"M10": {Code: "M10", Cards: []*mtgjson.Card{
+			{MTGJsonID: "1", SetCode: "M10", Name: "Lightning Bolt", Number: "146"},
+			{MTGJsonID: "2", SetCode: "M10", Name: "Mountain", Number: "242"},
+		}},
+	}
+	err := db.SaveCards(dbh, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := DeckList{}
+	d.AddToSection(sets["M10"].Cards[0], 4, sectionMain)
+	d.AddToSection(sets["M10"].Cards[0], 1, sectionSideboard)
+	d.AddToSection(sets["M10"].Cards[1], 20, sectionMain)
+	// Cards no longer in the db are kept by name
+	d.AddToSection(&mtgjson.Card{MTGJsonID: "gone", Name: "Chain Lightning"}, 2, sectionMaybe)
+
+	v, err := SaveDeck(dbh, "Burn", "", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, loaded, err := LoadDeckVersion(dbh, v.DeckID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := DiffDecks(d, loaded); len(diff.In)+len(diff.Out) != 0 {
+		t.Fatalf("Expected loaded deck to match the saved deck got %v", diff)
+	}
+	if loaded["Mountain"].Card.Number != "242" {
+		t.Fatalf("Expected saved printing to be loaded got %+v", loaded["Mountain"].Card)
+	}
+
+	_, err = SaveDeck(dbh, "Empty", "", DeckList{})
+	if err == nil {
+		t.Fatal("Expected error saving a deck with no cards")
+	}
+}
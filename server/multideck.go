@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/labstack/echo"
+)
+
+// NamedDeck is one of several decks to buy cards for
+type NamedDeck struct {
+	Name  string
+	Cards DeckList
+}
+
+// DeckNeed is how many copies of a card one deck needs
+type DeckNeed struct {
+	Deck  string `json:"deck"`
+	Count int    `json:"count"`
+}
+
+// Ways of combining the cards needed by several decks
+const (
+	// shareSleeved buys enough for every deck to be built at once
+	shareSleeved = "sleeved"
+	// shareShared moves cards between decks so only the most any one deck
+	// needs is bought
+	shareShared = "shared"
+)
+
+// MultiBuyList combines the buylists of several decks, each capped by
+// limit.  With shared the count of each card is the most any one deck
+// needs, otherwise it is the total needed by all of the decks.  The decks
+// needing each card are returned by card name.  limit.Decks only applies to
+// a single deck; with several each one is capped at one deck's worth.
+func MultiBuyList(decks []NamedDeck, limit PlaysetLimit, shared bool) (DeckList, map[string][]DeckNeed) {
+	if len(decks) > 1 {
+		limit.Decks = 1
+	}
+	needs := map[string][]DeckNeed{}
+	counts := map[string]int{}
+	entries := map[string]*CardEntry{}
+	for _, d := range decks {
+		for _, e := range d.Cards.BuyList(limit).sorted() {
+			name := e.Card.Name
+			needs[name] = append(needs[name], DeckNeed{Deck: d.Name, Count: e.Count})
+			entries[name] = e
+			if shared {
+				if e.Count > counts[name] {
+					counts[name] = e.Count
+				}
+			} else {
+				counts[name] += e.Count
+			}
+		}
+	}
+	buy := DeckList{}
+	for name, n := range counts {
+		buy.add(entries[name].Card, n)
+		buy.keepPrinting(entries[name])
+	}
+	return buy, needs
+}
+
+// buyListDecks reads the decks to buy cards for from the request: each
+// cardlist form value, named by the deckname value in the same position,
+// each cardlistfile upload, named after the file, and the latest version of
+// each saved deck id given as a deck form value.
+func (a *APIServer) buyListDecks(c echo.Context, excludebasic bool) ([]NamedDeck, []error, error) {
+	params, err := c.FormParams()
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	decks := []NamedDeck{}
+	errs := []error{}
+	names := params["deckname"]
+	for i, list := range params["cardlist"] {
+		if strings.TrimSpace(list) == "" {
+			continue
+		}
+		name := fmt.Sprintf("Deck %d", i+1)
+		if i < len(names) && strings.TrimSpace(names[i]) != "" {
+			name = strings.TrimSpace(names[i])
+		}
+		cards, cardErrs := readerToDeck(strings.NewReader(list), excludebasic, a.DBH)
+		decks = append(decks, NamedDeck{Name: name, Cards: cards})
+		errs = append(errs, cardErrs...)
+	}
+
+	if form, err := c.MultipartForm(); err == nil {
+		for _, fh := range form.File["cardlistfile"] {
+			src, err := fh.Open()
+			if err != nil {
+				return nil, nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Error opening form file: %s", err))
+			}
+			cards, cardErrs := readerToDeck(formFile{File: src, name: fh.Filename}, excludebasic, a.DBH)
+			src.Close()
+			name := strings.TrimSuffix(fh.Filename, filepath.Ext(fh.Filename))
+			decks = append(decks, NamedDeck{Name: name, Cards: cards})
+			errs = append(errs, cardErrs...)
+		}
+	}
+
+	for _, id := range params["deck"] {
+		if id == "" {
+			continue
+		}
+		deckID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid deck: '%s'", id))
+		}
+		saved, err := db.DeckByID(a.DBH, deckID)
+		if err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("No saved deck with id %d", deckID))
+		}
+		_, cards, err := LoadDeckVersion(a.DBH, deckID, 0)
+		if err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		if excludebasic {
+			for name, e := range cards {
+				if e.Card.IsBasicLand() {
+					delete(cards, name)
+				}
+			}
+		}
+		decks = append(decks, NamedDeck{Name: saved.Name, Cards: cards})
+	}
+
+	if len(decks) == 0 {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "No form or file input given")
+	}
+	return decks, errs, nil
+}
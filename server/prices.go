@@ -0,0 +1,267 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+	"github.com/labstack/echo"
+)
+
+// priceColumnAliases maps vendor CSV headers to the columns read by
+// ReadPriceCSV.
+var priceColumnAliases = map[string]string{
+	"mtg_json_id":      "uuid",
+	"card":             "name",
+	"card name":        "name",
+	"set code":         "set",
+	"setcode":          "set",
+	"collector number": "number",
+	"card number":      "number",
+	"price (usd)":      "price",
+	"retail":           "price",
+}
+
+// ReadPriceCSV reads a vendor price list with a header row.  Cards are
+// given by a uuid column or by name with optional set and number columns.
+// A price column is required; vendor defaults to the given vendor, finish
+// to normal (or foil if a foil column is true), date to today and currency
+// to USD.  Rows that can't be read are reported as errors and skipped.
+func ReadPriceCSV(dbh *db.Handle, r io.Reader, vendor string) ([]mtgjson.Price, []error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, []error{fmt.Errorf("Error reading CSV header: %s", err)}
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if alias, ok := priceColumnAliases[h]; ok {
+			h = alias
+		}
+		cols[h] = i
+	}
+	_, hasUUID := cols["uuid"]
+	_, hasName := cols["name"]
+	if !hasUUID && !hasName {
+		return nil, []error{fmt.Errorf("CSV has no uuid or name column")}
+	}
+	if _, ok := cols["price"]; !ok {
+		return nil, []error{fmt.Errorf("CSV has no price column")}
+	}
+	today := time.Now().Format("2006-01-02")
+
+	prices := []mtgjson.Price{}
+	errs := []error{}
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		p := mtgjson.Price{
+			MTGJsonID: field("uuid"),
+			Vendor:    strings.ToLower(field("vendor")),
+			Finish:    strings.ToLower(field("finish")),
+			Date:      field("date"),
+			Currency:  strings.ToUpper(field("currency")),
+		}
+		if p.MTGJsonID == "" {
+			if field("name") == "" {
+				continue
+			}
+			card, err := lookupPrinting(dbh, field("name"), field("set"), field("number"))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Line %d: %s", line, err))
+				continue
+			}
+			p.MTGJsonID = card.MTGJsonID
+		}
+		p.Price, err = strconv.ParseFloat(strings.TrimLeft(field("price"), "$€£"), 64)
+		if err != nil || p.Price < 0 {
+			errs = append(errs, fmt.Errorf("Line %d: Invalid price: '%s'", line, field("price")))
+			continue
+		}
+		if p.Vendor == "" {
+			p.Vendor = vendor
+		}
+		if p.Vendor == "" {
+			errs = append(errs, fmt.Errorf("Line %d: No vendor given", line))
+			continue
+		}
+		if p.Finish == "" {
+			p.Finish = db.FinishNormal
+			if parseFoil(field("foil")) {
+				p.Finish = "foil"
+			}
+		}
+		if p.Date == "" {
+			p.Date = today
+		}
+		if _, err := time.Parse("2006-01-02", p.Date); err != nil {
+			errs = append(errs, fmt.Errorf("Line %d: Invalid date, expected YYYY-MM-DD: '%s'", line, p.Date))
+			continue
+		}
+		if p.Currency == "" {
+			p.Currency = "USD"
+		}
+		prices = append(prices, p)
+	}
+	return prices, errs
+}
+
+// CardCost is the estimated cost of Count copies of a card at the price of
+// its cheapest printing.
+type CardCost struct {
+	Name     string  `json:"name"`
+	Count    int     `json:"count"`
+	SetCode  string  `json:"set,omitempty"`
+	Number   string  `json:"number,omitempty"`
+	Each     float64 `json:"each"`
+	Cost     float64 `json:"cost"`
+	Currency string  `json:"currency,omitempty"`
+}
+
+// CostEstimate is what a list of cards should cost from a vendor.  Cards
+// without a price from the vendor are left out of the total.
+type CostEstimate struct {
+	Vendor   string     `json:"vendor"`
+	Cards    []CardCost `json:"cards"`
+	Total    float64    `json:"total"`
+	Currency string     `json:"currency"`
+	Unpriced []string   `json:"unpriced"`
+}
+
+// Priced returns true if any of the cards had a price
+func (e *CostEstimate) Priced() bool {
+	return len(e.Cards) > 0
+}
+
+// EstimateCost prices d at the cheapest non-foil printing of each card sold
+// by vendor.
+func EstimateCost(dbh *db.Handle, d DeckList, vendor string) (*CostEstimate, error) {
+	est := &CostEstimate{Vendor: vendor, Cards: []CardCost{}, Unpriced: []string{}}
+	for _, e := range d.sorted() {
+		p, err := db.CheapestPrice(dbh, e.Card.Name, vendor, db.FinishNormal)
+		if err == sql.ErrNoRows {
+			est.Unpriced = append(est.Unpriced, e.Card.Name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cost := CardCost{
+			Name:     e.Card.Name,
+			Count:    e.Count,
+			SetCode:  p.SetCode,
+			Number:   p.Number,
+			Each:     p.Price.Price,
+			Cost:     p.Price.Price * float64(e.Count),
+			Currency: p.Currency,
+		}
+		est.Cards = append(est.Cards, cost)
+		est.Total += cost.Cost
+		if est.Currency == "" {
+			est.Currency = p.Currency
+		}
+	}
+	return est, nil
+}
+
+// PricePoint is a printing's price on a day
+type PricePoint struct {
+	Date  string  `json:"date"`
+	Price float64 `json:"price"`
+}
+
+// PriceSeries is the price history of a printing from a vendor in one
+// finish.
+type PriceSeries struct {
+	MTGJsonID string       `json:"uuid"`
+	SetCode   string       `json:"set"`
+	Number    string       `json:"number"`
+	Vendor    string       `json:"vendor"`
+	Finish    string       `json:"finish"`
+	Currency  string       `json:"currency"`
+	Prices    []PricePoint `json:"prices"`
+}
+
+// priceSeries groups prices ordered by printing, vendor, finish and date
+// into a series for each printing, vendor and finish.
+func priceSeries(prices []db.CardPrice) []PriceSeries {
+	series := []PriceSeries{}
+	for _, p := range prices {
+		last := len(series) - 1
+		if last < 0 || series[last].MTGJsonID != p.MTGJsonID || series[last].Vendor != p.Vendor || series[last].Finish != p.Finish {
+			series = append(series, PriceSeries{
+				MTGJsonID: p.MTGJsonID,
+				SetCode:   p.SetCode,
+				Number:    p.Number,
+				Vendor:    p.Vendor,
+				Finish:    p.Finish,
+				Currency:  p.Currency,
+				Prices:    []PricePoint{},
+			})
+			last++
+		}
+		series[last].Prices = append(series[last].Prices, PricePoint{Date: p.Date, Price: p.Price.Price})
+	}
+	return series
+}
+
+// cardPrices returns the price history of every printing of a card.  The
+// vendor and finish query params limit which series are returned and days
+// limits how far back from the latest prices they go.
+func (a *APIServer) cardPrices(c echo.Context) error {
+	cardname, err := url.QueryUnescape(c.Param("name"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Invalid input: %s", err))
+	}
+	card, err := db.ResolveCard(a.DBH, cardname)
+	if _, ok := err.(*db.UnknownCardError); ok {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	n := 0
+	if days := c.QueryParam("days"); days != "" {
+		n, err = strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid days: '%s'", days))
+		}
+	}
+	prices, err := db.PriceHistory(a.DBH, card.Name, strings.ToLower(c.QueryParam("vendor")), strings.ToLower(c.QueryParam("finish")), n)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	resp := struct {
+		Name   string        `json:"name"`
+		Series []PriceSeries `json:"series"`
+	}{card.Name, priceSeries(prices)}
+	b, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSONBlob(http.StatusOK, b)
+}
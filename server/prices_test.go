@@ -0,0 +1,85 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+func TestPriceCSVAndEstimate(t *testing.T) {
+	dbh := db.MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	sets := map[string]mtgjson.Set{
+		"LEA": {Code: "LEA", Cards: []*mtgjson.Card{
+			{MTGJsonID: "1", SetCode: "LEA", Name: "Shivan Dragon", Number: "174"},
+			{MTGJsonID: "2", SetCode: "LEA", Name: "Lightning Bolt", Number: "161"},
+		}},
+		"M10": {Code: "M10", Cards: []*mtgjson.Card{
+			{MTGJsonID: "3", SetCode: "M10", Name: "Shivan Dragon", Number: "158"},
+			{MTGJsonID: "4", SetCode: "M10", Name: "Mountain", Number: "242"},
+		}},
+	}
+	err := db.SaveCards(dbh, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := `Card Name,Set Code,Price,Foil,Date
+Shivan Dragon,LEA,$450.00,,2024-01-02
+Shivan Dragon,M10,0.50,,2024-01-02
+Shivan Dragon,M10,2.00,foil,2024-01-02
+Lightning Bolt,,abc,,2024-01-02
+Lightning Bolt,,300,,01/02/2024
+`
+	prices, errs := ReadPriceCSV(dbh, strings.NewReader(in), "scg")
+	if len(errs) != 2 {
+		t.Fatalf("Expected errors for the bad price and date got %v", errs)
+	}
+	if len(prices) != 3 || prices[0].Vendor != "scg" || prices[0].Price != 450 || prices[2].Finish != "foil" || prices[1].Currency != "USD" {
+		t.Fatalf("Expected 3 scg prices got %+v", prices)
+	}
+	err = db.SavePrices(dbh, prices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := DeckList{}
+	d.AddCard(sets["LEA"].Cards[0], 3)
+	d.AddCard(sets["M10"].Cards[1], 20)
+	est, err := EstimateCost(dbh, d, "scg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(est.Cards) != 1 || est.Cards[0].SetCode != "M10" || est.Total != 1.5 || est.Currency != "USD" {
+		t.Fatalf("Expected 3 of the cheapest non-foil printing got %+v", est)
+	}
+	if len(est.Unpriced) != 1 || est.Unpriced[0] != "Mountain" {
+		t.Fatalf("Expected Mountain to be unpriced got %v", est.Unpriced)
+	}
+}
+
+func TestPriceSeries(t *testing.T) {
+	price := func(id, finish, date string, amount float64) db.CardPrice {
+		return db.CardPrice{
+			Price:   mtgjson.Price{MTGJsonID: id, Vendor: "tcgplayer", Finish: finish, Date: date, Price: amount, Currency: "USD"},
+			SetCode: "LEA",
+		}
+	}
+	series := priceSeries([]db.CardPrice{
+		price("1", "normal", "2024-01-01", 400),
+		price("1", "normal", "2024-01-02", 410),
+		price("1", "foil", "2024-01-01", 900),
+		price("2", "normal", "2024-01-01", 1),
+	})
+	if len(series) != 3 {
+		t.Fatalf("Expected a series per printing and finish got %+v", series)
+	}
+	if series[0].Finish != "normal" || len(series[0].Prices) != 2 || series[0].Prices[1] != (PricePoint{"2024-01-02", 410}) {
+		t.Fatalf("Expected two normal prices for the first printing got %+v", series[0])
+	}
+	if series[1].Finish != "foil" || series[2].MTGJsonID != "2" || series[2].SetCode != "LEA" {
+		t.Fatalf("Expected foil then second printing series got %+v", series[1:])
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
 	"github.com/labstack/echo"
 )
 
@@ -28,12 +30,20 @@ var (
 		// To be implemented
 		// Multicolor
 		"multiverseid": "multiverse_id",
-		//"format": "Legalities",
+		"format":       "format",
 		//"status": "Status"
+		"set":             "set",
+		"block":           "block",
+		"settype":         "settype",
+		"released_before": "released_before",
+		"released_after":  "released_after",
 	}
 )
 
 func (a *APIServer) handleCards(c echo.Context) error {
+	if q := c.QueryParam("q"); q != "" {
+		return a.handleCardQuery(c, q)
+	}
 	params := c.QueryParams()
 	columns := []string{}
 	values := [][]string{}
@@ -47,14 +57,77 @@ func (a *APIServer) handleCards(c echo.Context) error {
 	if len(columns) < 1 {
 		return echo.NewHTTPError(http.StatusNoContent, "No arguments given")
 	}
-	cards, err := db.SearchCards(a.DBH, columns, values)
+	opts, err := searchOptions(c)
+	if err != nil {
+		return err
+	}
+	cards, total, err := db.SearchCards(a.DBH, columns, values, opts)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return a.writeCardPage(c, cards, total, opts)
+}
+
+// handleCardQuery searches using the query language, e.g.
+// /v1/cards?q=c:rg+t:creature+cmc<=3
+func (a *APIServer) handleCardQuery(c echo.Context, q string) error {
+	query, err := db.ParseQuery(q)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid query: %s", err))
+	}
+	opts, err := searchOptions(c)
+	if err != nil {
+		return err
+	}
+	cards, total, err := db.SearchCardsParsed(a.DBH, query, opts)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return a.writeCardPage(c, cards, total, opts)
+}
+
+// searchOptions reads the page, per_page, sort and order query parameters
+func searchOptions(c echo.Context) (db.SearchOptions, error) {
+	opts := db.SearchOptions{}
+	page, perPage, err := pageParams(c)
+	if err != nil {
+		return opts, err
+	}
+	opts.Limit = perPage
+	opts.Offset = (page - 1) * perPage
+	if s := c.QueryParam("sort"); s != "" {
+		if _, ok := db.SortColumns[s]; !ok {
+			return opts, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unknown sort: '%s'", s))
+		}
+		opts.Sort = s
+	}
+	switch strings.ToLower(c.QueryParam("order")) {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, echo.NewHTTPError(http.StatusBadRequest, "order must be asc or desc")
+	}
+	return opts, nil
+}
+
+// writeCardPage sends one page of search results along with the
+// X-Total-Count and Link headers describing the rest of the results.
+func (a *APIServer) writeCardPage(c echo.Context, cards []mtgjson.Card, total int, opts db.SearchOptions) error {
+	err := db.AddLegalities(a.DBH, db.CardPointers(cards)...)
 	if err != nil {
-		echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 	b, err := json.MarshalIndent(cards, "", "  ")
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
+	h := c.Response().Header()
+	h.Set("X-Total-Count", strconv.Itoa(total))
+	page := opts.Offset/opts.Limit + 1
+	if links := pageLinks(c.Request().URL, page, opts.Limit, total); links != "" {
+		h.Set("Link", links)
+	}
 	return c.JSONBlob(http.StatusOK, b)
 }
 
@@ -71,6 +144,16 @@ func (a *APIServer) cardByName(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	err = db.AddLegalities(a.DBH, card)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if c.QueryParam("rulings") == "true" {
+		card.Rulings, err = db.RulingsByName(a.DBH, card.Name)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
 	card.ImageURL = fmt.Sprintf("https://192.168.1.5/img/%s/%s.full.jpg", card.SetCode, card.Name)
 	b, err := json.MarshalIndent(card, "", "  ")
 	if err != nil {
@@ -79,6 +162,29 @@ func (a *APIServer) cardByName(c echo.Context) error {
 	return c.JSONBlob(http.StatusOK, b)
 }
 
+func (a *APIServer) cardRulings(c echo.Context) error {
+	cardname, err := url.QueryUnescape(c.Param("name"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Invalid input: %s", err))
+	}
+	card, err := db.CardByName(a.DBH, cardname)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "No card with that name")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	rulings, err := db.RulingsByName(a.DBH, card.Name)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	b, err := json.MarshalIndent(rulings, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSONBlob(http.StatusOK, b)
+}
+
 func (a *APIServer) cardByMyltiverseID(c echo.Context) error {
 	cardid := c.Param("id")
 	card, err := db.CardByMTGJsonID(a.DBH, cardid)
@@ -88,6 +194,10 @@ func (a *APIServer) cardByMyltiverseID(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	err = db.AddLegalities(a.DBH, card)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
 	b, err := json.MarshalIndent(card, "", "  ")
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError)
@@ -95,10 +205,90 @@ func (a *APIServer) cardByMyltiverseID(c echo.Context) error {
 	return c.JSONBlob(http.StatusOK, b)
 }
 
+// autocomplete returns card names matching the start of q for type-ahead,
+// e.g. /v1/autocomplete?q=sel&limit=10
+func (a *APIServer) autocomplete(c echo.Context) error {
+	limit := defaultCompletions
+	if l := c.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxCompletions {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxCompletions))
+		}
+		limit = n
+	}
+	names, err := a.DBH.Names()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	b, err := json.MarshalIndent(names.Complete(c.QueryParam("q"), limit), "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSONBlob(http.StatusOK, b)
+}
+
 /*
 * Utils
  */
 
+const (
+	defaultPerPage = 100
+	maxPerPage     = 500
+
+	defaultCompletions = 20
+	maxCompletions     = 100
+)
+
+// pageParams reads the 1 based page and per_page query parameters
+func pageParams(c echo.Context) (int, int, error) {
+	page, perPage := 1, defaultPerPage
+	if p := c.QueryParam("page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid page: '%s'", p))
+		}
+		page = n
+	}
+	if p := c.QueryParam("per_page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 || n > maxPerPage {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("per_page must be between 1 and %d", maxPerPage))
+		}
+		perPage = n
+	}
+	return page, perPage, nil
+}
+
+// pageLinks builds an RFC 5988 Link header value pointing at the first,
+// previous, next and last pages of a result set.
+func pageLinks(u *url.URL, page, perPage, total int) string {
+	last := (total + perPage - 1) / perPage
+	if last < 1 {
+		last = 1
+	}
+	link := func(p int, rel string) string {
+		l := *u
+		q := l.Query()
+		q.Set("page", strconv.Itoa(p))
+		q.Set("per_page", strconv.Itoa(perPage))
+		l.RawQuery = q.Encode()
+		return fmt.Sprintf("<%s>; rel=\"%s\"", l.RequestURI(), rel)
+	}
+	links := []string{}
+	if page > 1 {
+		// Past the end the previous page is the last one with results
+		prev := page - 1
+		if prev > last {
+			prev = last
+		}
+		links = append(links, link(1, "first"), link(prev, "prev"))
+	}
+	if page < last {
+		links = append(links, link(page+1, "next"), link(last, "last"))
+	}
+	return strings.Join(links, ", ")
+}
+
 func lowerStringSlice(s []string) []string {
 	for i, v := range s {
 		s[i] = strings.ToLower(v)
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestPageLinks(t *testing.T) {
+	u, _ := url.Parse("/v1/cards?type=creature&page=2&per_page=10")
+	links := pageLinks(u, 2, 10, 35)
+	expected := `</v1/cards?page=1&per_page=10&type=creature>; rel="first", ` +
+		`</v1/cards?page=1&per_page=10&type=creature>; rel="prev", ` +
+		`</v1/cards?page=3&per_page=10&type=creature>; rel="next", ` +
+		`</v1/cards?page=4&per_page=10&type=creature>; rel="last"`
+	if links != expected {
+		t.Fatalf("Expected %s\ngot %s", expected, links)
+	}
+
+	if links := pageLinks(u, 1, 10, 5); links != "" {
+		t.Fatalf("Expected no links for a single page, got %s", links)
+	}
+
+	links = pageLinks(u, 9, 10, 35)
+	expected = `</v1/cards?page=1&per_page=10&type=creature>; rel="first", ` +
+		`</v1/cards?page=4&per_page=10&type=creature>; rel="prev"`
+	if links != expected {
+		t.Fatalf("Expected prev to be the last page past the end\n%s\ngot %s", expected, links)
+	}
+}
@@ -25,6 +25,12 @@ type APIServer struct {
 
 // Serve sets up and starts the server
 func (s *APIServer) Serve() error {
+	// Build the name index up front rather than on the first request
+	_, err := s.DBH.Names()
+	if err != nil {
+		return fmt.Errorf("Error building name index: %s", err)
+	}
+
 	e := echo.New()
 	e.Debug = true
 	e.Use(middleware.Logger())
@@ -36,9 +42,33 @@ func (s *APIServer) Serve() error {
 	e.GET("/v1/cards", s.handleCards)
 	e.GET("/v1/cardid/:id", s.cardByMyltiverseID)
 	e.GET("/v1/card/:name", s.cardByName)
+	e.GET("/v1/card/:name/rulings", s.cardRulings)
+	e.GET("/v1/card/:name/prices", s.cardPrices)
+	e.GET("/v1/sets", s.handleSets)
+	e.GET("/v1/sets/:code", s.setByCode)
+	e.GET("/v1/autocomplete", s.autocomplete)
 
 	e.File("/s/buylist", "public/buylist.html")
 	e.POST("/v1/buylist", s.formatBuyList)
+	e.POST("/v1/decks/validate", s.validateDeck)
+	e.GET("/v1/decks", s.listDecks)
+	e.POST("/v1/decks", s.saveDeck)
+	e.GET("/v1/decks/:id", s.getDeck)
+	e.DELETE("/v1/decks/:id", s.deleteDeck)
+	e.GET("/v1/decks/:id/versions", s.deckVersions)
+	e.GET("/v1/decks/:id/diff", s.diffDeck)
+	e.POST("/v1/decks/:id/versions/:version/restore", s.restoreDeck)
+
+	e.GET("/v1/collections", s.listCollections)
+	e.POST("/v1/collections", s.createCollection)
+	e.GET("/v1/collections/:id", s.getCollection)
+	e.PUT("/v1/collections/:id", s.renameCollection)
+	e.DELETE("/v1/collections/:id", s.deleteCollection)
+	e.POST("/v1/collections/:id/cards", s.addCollectionCard)
+	e.PUT("/v1/collections/:id/cards/:card", s.updateCollectionCard)
+	e.DELETE("/v1/collections/:id/cards/:card", s.deleteCollectionCard)
+	e.GET("/v1/collections/:id/csv", s.exportCollection)
+	e.POST("/v1/collections/:id/csv", s.importCollection)
 
 	e.Static("/img/", "/home/hobe/.forge/pics/cards/")
 
@@ -61,13 +91,35 @@ func (s *APIServer) Serve() error {
 		{{end}}
 		</ul>
 		<br/>
-		DeckList:
+		{{range .Sections}}
+		{{.Name}}:
 		<ul>
-		{{range $key, $value := .Deck}}
+		{{range $key, $value := .Cards}}
 		<li>{{$value.Count}}  {{$value.Card.Name}}</li>
 		{{end}}
 		</ul>
-		<a href="http://store.tcgplayer.com/list/selectproductmagic.aspx?c={{.Deck.TCGList}}">Buy on TCGPlayer </a>
+		{{end}}
+		<br/>
+		Buy:
+		<ul>
+		{{range $key, $value := .Deck}}
+		<li>{{$value.Count}}  {{$value.Card.Name}}{{with index $.Needs $key}} ({{range $i, $n := .}}{{if $i}}, {{end}}{{$n.Deck}}: {{$n.Count}}{{end}}){{end}}</li>
+		{{end}}
+		</ul>
+		{{if .FullCost.Priced}}
+		Estimated cost from {{.Vendor.Title}}: {{printf "%.2f" .Cost.Total}} {{.FullCost.Currency}}
+		({{printf "%.2f" .FullCost.Total}} {{.FullCost.Currency}} before subtracting the cards you have)
+		<table>
+		<tr><th>Count</th><th>Card</th><th>Cheapest printing</th><th>Each</th><th>Cost</th></tr>
+		{{range .Cost.Cards}}
+		<tr><td>{{.Count}}</td><td>{{.Name}}</td><td>{{.SetCode}} {{.Number}}</td><td>{{printf "%.2f" .Each}}</td><td>{{printf "%.2f" .Cost}}</td></tr>
+		{{end}}
+		</table>
+		{{if .Cost.Unpriced}}No price for: {{range $i, $n := .Cost.Unpriced}}{{if $i}}, {{end}}{{$n}}{{end}}<br/>{{end}}
+		{{end}}
+		<a href="{{.Vendor.URL}}">Buy on {{.Vendor.Title}}</a><br/>
+		Paste into {{.Vendor.Title}}'s mass entry:<br/>
+		<textarea rows="24" cols="80" readonly>{{.Vendor.MassEntry}}</textarea>
 
 </body>
 		</html>`)),
@@ -81,7 +133,7 @@ func (s *APIServer) Serve() error {
 		MaxHeaderBytes: 2048,
 	}
 
-	err := e.StartServer(customServer)
+	err = e.StartServer(customServer)
 	if err != nil {
 		return fmt.Errorf("Error starting server: %s", err)
 	}
@@ -103,7 +155,7 @@ func headers(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		//		c.Response().Header().Set("Content-Type", "application/json; charset=utf-8")
 		c.Response().Header().Set("Access-Control-Allow-Origin", "*")
-		c.Response().Header().Set("Access-Control-Expose-Headers", "link,content-length")
+		c.Response().Header().Set("Access-Control-Expose-Headers", "link,content-length,x-total-count")
 		c.Response().Header().Set("License", "The textual information presented through this API about Magic: The Gathering is copyrighted by Wizards of the Coast.")
 		c.Response().Header().Set("Disclaimer", "This API is not produced, endorsed, supported, or affiliated with Wizards of the Coast.")
 		c.Response().Header().Set("Strict-Transport-Security", "max-age=86400")
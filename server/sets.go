@@ -0,0 +1,71 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+	"github.com/hobeone/mtgbrew/types"
+	"github.com/labstack/echo"
+)
+
+type setResp struct {
+	types.Set
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+	Total   int            `json:"total"`
+	Cards   []mtgjson.Card `json:"cards"`
+}
+
+func (a *APIServer) handleSets(c echo.Context) error {
+	sets, err := db.Sets(a.DBH)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	resp := make([]types.Set, len(sets))
+	for i, s := range sets {
+		resp[i] = types.NewSet(s)
+	}
+	b, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSONBlob(http.StatusOK, b)
+}
+
+func (a *APIServer) setByCode(c echo.Context) error {
+	set, err := db.SetByCode(a.DBH, c.Param("code"))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "No set with that code")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	page, perPage, err := pageParams(c)
+	if err != nil {
+		return err
+	}
+	opts := db.SearchOptions{
+		Sort:   "number",
+		Limit:  perPage,
+		Offset: (page - 1) * perPage,
+	}
+	cards, total, err := db.CardsInSet(a.DBH, set.Code, opts)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	resp := setResp{
+		Set:     types.NewSet(*set),
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+		Cards:   cards,
+	}
+	b, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSONBlob(http.StatusOK, b)
+}
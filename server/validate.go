@@ -0,0 +1,343 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+	"github.com/labstack/echo"
+)
+
+// formatRules describes the deck construction rules of a format
+type formatRules struct {
+	MinMain      int
+	MaxMain      int // 0 for no maximum
+	MaxSideboard int
+	MaxCopies    int
+	Commander    bool
+}
+
+var (
+	constructedRules = formatRules{
+		MinMain:      60,
+		MaxSideboard: 15,
+		MaxCopies:    4,
+	}
+
+	// Formats not listed here use constructedRules
+	formatRuleSets = map[string]formatRules{
+		"commander": {MinMain: 100, MaxMain: 100, MaxCopies: 1, Commander: true},
+		"duel":      {MinMain: 100, MaxMain: 100, MaxCopies: 1, Commander: true},
+		"brawl":     {MinMain: 60, MaxMain: 60, MaxCopies: 1, Commander: true},
+	}
+
+	anyNumberRegexp = regexp.MustCompile(`(?i)a deck can have any number of cards named`)
+	upToRegexp      = regexp.MustCompile(`(?i)a deck can have up to (\w+) cards named`)
+
+	numberWords = map[string]int{
+		"one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+		"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10,
+	}
+)
+
+// Violation describes a single reason a deck isn't legal.  Card is empty for
+// problems with the deck as a whole.
+type Violation struct {
+	Card    string `json:"card,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationResult is the outcome of checking a deck against a format
+type ValidationResult struct {
+	Format         string      `json:"format"`
+	Legal          bool        `json:"legal"`
+	MainCount      int         `json:"main_count"`
+	SideboardCount int         `json:"sideboard_count"`
+	Violations     []Violation `json:"violations"`
+}
+
+// validationDeck holds a deck with uncapped card counts
+type validationDeck struct {
+	Main       DeckList
+	Sideboard  DeckList
+	Commanders []*mtgjson.Card
+	// SeparateCommanders is true if the commanders were listed in their own
+	// section so copies of them in Main are extra cards, not the commander.
+	SeparateCommanders bool
+}
+
+// copyLimit returns how many copies of card a deck may contain, or -1 for no
+// limit.  Cards without their own rule are limited to maxCopies.
+func copyLimit(card *mtgjson.Card, maxCopies int) int {
+	if card.IsBasicLand() || anyNumberRegexp.MatchString(card.Text) {
+		return -1
+	}
+	if m := upToRegexp.FindStringSubmatch(card.Text); m != nil {
+		if n, ok := numberWords[strings.ToLower(m[1])]; ok {
+			return n
+		}
+		if n, err := strconv.Atoi(m[1]); err == nil {
+			return n
+		}
+	}
+	return maxCopies
+}
+
+func legalityIn(card *mtgjson.Card, format string) string {
+	for _, l := range card.Legalities {
+		if l.Format == format {
+			return l.Legality
+		}
+	}
+	return ""
+}
+
+func canBeCommander(card *mtgjson.Card) bool {
+	t := strings.ToLower(card.Type)
+	if strings.Contains(t, "legendary") && strings.Contains(t, "creature") {
+		return true
+	}
+	return strings.Contains(strings.ToLower(card.Text), "can be your commander")
+}
+
+func (v validationDeck) validate(format string) ValidationResult {
+	format = strings.ToLower(format)
+	rules, ok := formatRuleSets[format]
+	if !ok {
+		rules = constructedRules
+	}
+	res := ValidationResult{
+		Format:         format,
+		MainCount:      v.Main.Total(),
+		SideboardCount: v.Sideboard.Total(),
+		Violations:     []Violation{},
+	}
+	violate := func(card, msg string, args ...interface{}) {
+		res.Violations = append(res.Violations, Violation{Card: card, Message: fmt.Sprintf(msg, args...)})
+	}
+
+	mainCount := res.MainCount
+	if rules.Commander {
+		for _, cmdr := range v.Commanders {
+			if _, inMain := v.Main[cmdr.Name]; !inMain || v.SeparateCommanders {
+				mainCount++
+			}
+		}
+	}
+	if mainCount < rules.MinMain {
+		violate("", "Deck has %d cards, needs at least %d", mainCount, rules.MinMain)
+	}
+	if rules.MaxMain > 0 && mainCount > rules.MaxMain {
+		violate("", "Deck has %d cards, can have at most %d", mainCount, rules.MaxMain)
+	}
+
+	// Cards in both lists count against the same limit
+	combined := map[string]*CardEntry{}
+	for _, list := range []DeckList{v.Main, v.Sideboard} {
+		for name, e := range list {
+			if c, ok := combined[name]; ok {
+				combined[name] = &CardEntry{Card: c.Card, Count: c.Count + e.Count}
+			} else {
+				combined[name] = &CardEntry{Card: e.Card, Count: e.Count}
+			}
+		}
+	}
+
+	if rules.Commander {
+		if len(v.Commanders) == 0 {
+			violate("", "No commander given")
+		}
+		identity := map[string]bool{}
+		for _, cmdr := range v.Commanders {
+			if !canBeCommander(cmdr) {
+				violate(cmdr.Name, "Can't be a commander")
+			}
+			for _, c := range cmdr.ColorIdentity {
+				identity[strings.ToUpper(c)] = true
+			}
+			if c, ok := combined[cmdr.Name]; !ok {
+				combined[cmdr.Name] = &CardEntry{Card: cmdr, Count: 1}
+			} else if v.SeparateCommanders {
+				combined[cmdr.Name] = &CardEntry{Card: c.Card, Count: c.Count + 1}
+			}
+		}
+		isCommander := map[string]bool{}
+		for _, cmdr := range v.Commanders {
+			isCommander[cmdr.Name] = true
+		}
+		for name := range v.Sideboard {
+			if !isCommander[name] {
+				violate(name, "%s decks can't have a sideboard", format)
+			}
+		}
+		if len(v.Commanders) > 0 {
+			for name, e := range combined {
+				for _, c := range e.Card.ColorIdentity {
+					if !identity[strings.ToUpper(c)] {
+						violate(name, "Outside the commander's color identity")
+						break
+					}
+				}
+			}
+		}
+	} else if res.SideboardCount > rules.MaxSideboard {
+		violate("", "Sideboard has %d cards, can have at most %d", res.SideboardCount, rules.MaxSideboard)
+	}
+
+	for name, e := range combined {
+		limit := copyLimit(e.Card, rules.MaxCopies)
+		switch legalityIn(e.Card, format) {
+		case "legal":
+		case "restricted":
+			limit = 1
+		case "banned":
+			violate(name, "Banned in %s", format)
+			continue
+		default:
+			violate(name, "Not legal in %s", format)
+			continue
+		}
+		if limit >= 0 && e.Count > limit {
+			violate(name, "%d copies, limit is %d", e.Count, limit)
+		}
+	}
+
+	sort.SliceStable(res.Violations, func(i, j int) bool {
+		if res.Violations[i].Card != res.Violations[j].Card {
+			return res.Violations[i].Card < res.Violations[j].Card
+		}
+		return res.Violations[i].Message < res.Violations[j].Message
+	})
+	res.Legal = len(res.Violations) == 0
+	return res
+}
+
+// ValidateDeck checks the deck list read from r against the rules and
+// banned/restricted list of format.  For commander formats the commander can
+// be given by name, otherwise the deck's commander section or sideboard is
+// used.
+func ValidateDeck(dbh *db.Handle, r io.Reader, format string, commander string) (*ValidationResult, error) {
+	formats, err := db.Formats(dbh)
+	if err != nil {
+		return nil, err
+	}
+	format = strings.ToLower(format)
+	known := false
+	for _, f := range formats {
+		if f == format {
+			known = true
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("Unknown format '%s'", format)
+	}
+
+	deck := validationDeck{
+		Main:      DeckList{},
+		Sideboard: DeckList{},
+	}
+	cards := []*mtgjson.Card{}
+	commanders := []*mtgjson.Card{}
+	// commanderCounts is how many copies of each card are in the commander
+	// section, as opposed to the main deck
+	commanderCounts := map[string]int{}
+	errs := readDeck(r, dbh, func(card *mtgjson.Card, e DeckEntry) error {
+		cards = append(cards, card)
+		switch e.Section {
+		case sectionCommander:
+			err := deck.Main.add(card, e.Count)
+			if err != nil {
+				return err
+			}
+			if _, ok := commanderCounts[card.Name]; !ok {
+				commanders = append(commanders, card)
+			}
+			commanderCounts[card.Name] += e.Count
+			return nil
+		case sectionSideboard, sectionCompanion:
+			return deck.Sideboard.add(card, e.Count)
+		}
+		return deck.Main.add(card, e.Count)
+	})
+
+	if _, ok := formatRuleSets[format]; ok {
+		if len(commanders) > 0 && commander == "" {
+			// Commanders are counted separately from the main deck, any
+			// other copies stay in it
+			for name, n := range commanderCounts {
+				e, ok := deck.Main[name]
+				if !ok {
+					continue
+				}
+				e.Count -= n
+				e.Sections[sectionMain] -= n
+				if e.Count <= 0 {
+					delete(deck.Main, name)
+				}
+			}
+			deck.Commanders = commanders
+			deck.SeparateCommanders = true
+		} else if commander != "" {
+			for _, name := range strings.Split(commander, "//") {
+				card, err := db.ResolveCard(dbh, name)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("Commander: %s", err))
+					continue
+				}
+				cards = append(cards, card)
+				deck.Commanders = append(deck.Commanders, card)
+			}
+		} else {
+			for _, e := range deck.Sideboard {
+				deck.Commanders = append(deck.Commanders, e.Card)
+			}
+		}
+	}
+
+	err = db.AddLegalities(dbh, cards...)
+	if err != nil {
+		return nil, err
+	}
+	res := deck.validate(format)
+	for _, e := range errs {
+		res.Violations = append(res.Violations, Violation{Message: e.Error()})
+	}
+	res.Legal = len(res.Violations) == 0
+	return &res, nil
+}
+
+func (a *APIServer) validateDeck(c echo.Context) error {
+	format := c.FormValue("format")
+	if format == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "No format given")
+	}
+
+	var cardreader io.Reader
+	if cardlist := c.FormValue("cardlist"); cardlist != "" {
+		cardreader = strings.NewReader(cardlist)
+	} else {
+		src, err := formFileReader(c, "cardlistfile")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		defer src.Close()
+		cardreader = src
+	}
+
+	res, err := ValidateDeck(a.DBH, cardreader, format, c.FormValue("commander"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	b, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSONBlob(http.StatusOK, b)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+	_ "github.com/mattn/go-sqlite3" // import sqlite driver
+)
+
+func legalCard(name string, legalities ...string) *mtgjson.Card {
+	c := &mtgjson.Card{Name: name, Type: "Instant"}
+	for i := 0; i+1 < len(legalities); i += 2 {
+		c.Legalities = append(c.Legalities, mtgjson.Legality{Format: legalities[i], Legality: legalities[i+1]})
+	}
+	return c
+}
+
+func hasViolation(res ValidationResult, card string) bool {
+	for _, v := range res.Violations {
+		if v.Card == card {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidateConstructed(t *testing.T) {
+	bolt := legalCard("Lightning Bolt", "modern", "legal")
+	mountain := legalCard("Mountain", "modern", "legal")
+	mountain.Rarity = "Basic Land"
+	rats := legalCard("Relentless Rats", "modern", "legal")
+	rats.Text = "A deck can have any number of cards named Relentless Rats."
+	dwarves := legalCard("Seven Dwarves", "modern", "legal")
+	dwarves.Text = "A deck can have up to seven cards named Seven Dwarves."
+	ponder := legalCard("Ponder", "modern", "banned")
+	lotus := legalCard("Black Lotus", "vintage", "restricted")
+
+	deck := validationDeck{Main: DeckList{}, Sideboard: DeckList{}}
+	deck.Main.add(bolt, 4)
+	deck.Main.add(mountain, 30)
+	deck.Main.add(rats, 10)
+	deck.Main.add(dwarves, 7)
+	deck.Sideboard.add(bolt, 1)
+	deck.Sideboard.add(ponder, 1)
+
+	res := deck.validate("modern")
+	if res.Legal {
+		t.Fatal("Expected deck to be illegal")
+	}
+	if res.MainCount != 51 || res.SideboardCount != 2 {
+		t.Fatalf("Expected 51 main and 2 sideboard cards, got %d and %d", res.MainCount, res.SideboardCount)
+	}
+	for _, name := range []string{"", "Lightning Bolt", "Ponder"} {
+		if !hasViolation(res, name) {
+			t.Errorf("Expected a violation for '%s': %v", name, res.Violations)
+		}
+	}
+	for _, name := range []string{"Mountain", "Relentless Rats", "Seven Dwarves"} {
+		if hasViolation(res, name) {
+			t.Errorf("Expected no violation for '%s': %v", name, res.Violations)
+		}
+	}
+
+	deck = validationDeck{Main: DeckList{}, Sideboard: DeckList{}}
+	deck.Main.add(lotus, 2)
+	res = deck.validate("vintage")
+	if !hasViolation(res, "Black Lotus") {
+		t.Errorf("Expected restricted card violation: %v", res.Violations)
+	}
+}
+
+func TestValidateCommander(t *testing.T) {
+	cmdr := legalCard("Krenko, Mob Boss", "commander", "legal")
+	cmdr.Type = "Legendary Creature — Goblin Warrior"
+	cmdr.ColorIdentity = mtgjson.StringSlice{"R"}
+	bolt := legalCard("Lightning Bolt", "commander", "legal")
+	bolt.ColorIdentity = mtgjson.StringSlice{"R"}
+	counterspell := legalCard("Counterspell", "commander", "legal")
+	counterspell.ColorIdentity = mtgjson.StringSlice{"U"}
+	mountain := legalCard("Mountain", "commander", "legal")
+	mountain.Rarity = "Basic Land"
+
+	deck := validationDeck{Main: DeckList{}, Sideboard: DeckList{}}
+	deck.Main.add(bolt, 2)
+	deck.Main.add(counterspell, 1)
+	deck.Main.add(mountain, 96)
+	deck.Sideboard.add(cmdr, 1)
+	deck.Commanders = []*mtgjson.Card{cmdr}
+
+	res := deck.validate("commander")
+	if res.Legal {
+		t.Fatal("Expected deck to be illegal")
+	}
+	if !hasViolation(res, "Lightning Bolt") {
+		t.Errorf("Expected singleton violation: %v", res.Violations)
+	}
+	if !hasViolation(res, "Counterspell") {
+		t.Errorf("Expected color identity violation: %v", res.Violations)
+	}
+	if hasViolation(res, "") || hasViolation(res, "Krenko, Mob Boss") || hasViolation(res, "Mountain") {
+		t.Errorf("Unexpected violations: %v", res.Violations)
+	}
+}
+
+func TestValidateDeckArena(t *testing.T) {
+	dbh := db.MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	krenko := legalCard("Krenko, Mob Boss", "commander", "legal")
+	krenko.Type = "Legendary Creature — Goblin Warrior"
+	krenko.ColorIdentity = mtgjson.StringSlice{"R"}
+	mountain := legalCard("Mountain", "commander", "legal")
+	mountain.Rarity = "Basic Land"
+	sets := map[string]mtgjson.Set{}
+	for i, code := range []string{"DDT", "M19"} {
+		cards := []*mtgjson.Card{}
+		for _, c := range []*mtgjson.Card{krenko, mountain} {
+			printing := *c
+			printing.MTGJsonID = code + c.Name
+			printing.SetCode = code
+			printing.Number = "1"
+			printing.ReleaseDate = time.Date(2010+i, 1, 1, 0, 0, 0, 0, time.UTC)
+			cards = append(cards, &printing)
+		}
+		sets[code] = mtgjson.Set{Code: code, Cards: cards}
+	}
+	err := db.SaveCards(dbh, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deck := "Commander\n1 Krenko, Mob Boss (DDT) 1\n\nDeck\n99 Mountain (M19) 1\n"
+	res, err := ValidateDeck(dbh, strings.NewReader(deck), "commander", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Legal || res.MainCount != 99 {
+		t.Fatalf("Expected a legal deck of 99 cards and a commander, got %v", res)
+	}
+
+	// A copy of the commander in the main deck still counts against singleton
+	extra := "Commander\n1 Krenko, Mob Boss (DDT) 1\n\nDeck\n1 Krenko, Mob Boss\n98 Mountain (M19) 1\n"
+	res, err = ValidateDeck(dbh, strings.NewReader(extra), "commander", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Legal || res.MainCount != 99 || !hasViolation(*res, "Krenko, Mob Boss") {
+		t.Fatalf("Expected a singleton violation for the extra Krenko, got %v", res)
+	}
+
+	// A commander line without any copies is an error, not a crash
+	res, err = ValidateDeck(dbh, strings.NewReader("[Commander]\n0 Krenko, Mob Boss\n"), "commander", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Legal || !hasViolation(*res, "") {
+		t.Fatalf("Expected violations for the empty commander line, got %v", res)
+	}
+
+	var printing *mtgjson.Card
+	readDeck(strings.NewReader(deck), dbh, func(card *mtgjson.Card, e DeckEntry) error {
+		if e.Section == sectionCommander {
+			printing = card
+		}
+		return nil
+	})
+	if printing == nil || printing.SetCode != "DDT" {
+		t.Fatalf("Expected the DDT printing of Krenko, got %v", printing)
+	}
+}
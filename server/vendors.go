@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Vendor formats a buylist for a card store's mass entry form
+type Vendor interface {
+	// Name is the short name used to pick the vendor, e.g. "cardmarket"
+	Name() string
+	// Title is the vendor's display name
+	Title() string
+	// MassEntry formats d to paste into the vendor's mass entry form
+	MassEntry(d DeckList) string
+	// URL is the vendor's mass entry page, including the list if the vendor
+	// takes it as a parameter.
+	URL(d DeckList) string
+}
+
+var vendors = []Vendor{
+	tcgPlayer{},
+	cardKingdom{},
+	cardmarket{},
+	starCityGames{},
+}
+
+// VendorFor returns the Vendor with the given short name
+func VendorFor(name string) (Vendor, error) {
+	for _, v := range vendors {
+		if v.Name() == name {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown vendor: '%s'", name)
+}
+
+// massEntryLines formats each card in d with line, sorted by card name
+func massEntryLines(d DeckList, line func(e *CardEntry) string) []string {
+	entries := d.sorted()
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		lines[i] = line(e)
+	}
+	return lines
+}
+
+// nameCountLines formats d as "4 Lightning Bolt" lines for vendors that
+// match on card names alone.
+func nameCountLines(d DeckList) string {
+	return strings.Join(massEntryLines(d, func(e *CardEntry) string {
+		return fmt.Sprintf("%d %s", e.Count, e.Card.Name)
+	}), "\n")
+}
+
+// tcgPlayer takes the list in the mass entry URL, see DeckList.TCGList
+type tcgPlayer struct{}
+
+func (tcgPlayer) Name() string  { return "tcgplayer" }
+func (tcgPlayer) Title() string { return "TCGPlayer" }
+
+func (tcgPlayer) MassEntry(d DeckList) string {
+	return d.TCGList()
+}
+
+func (v tcgPlayer) URL(d DeckList) string {
+	return "http://store.tcgplayer.com/list/selectproductmagic.aspx?c=" + url.QueryEscape(v.MassEntry(d))
+}
+
+// cardKingdom's deck builder matches on card names alone
+type cardKingdom struct{}
+
+func (cardKingdom) Name() string  { return "cardkingdom" }
+func (cardKingdom) Title() string { return "Card Kingdom" }
+
+func (cardKingdom) MassEntry(d DeckList) string {
+	return nameCountLines(d)
+}
+
+func (cardKingdom) URL(d DeckList) string {
+	return "https://www.cardkingdom.com/builder"
+}
+
+// starCityGames' mass entry matches on card names alone
+type starCityGames struct{}
+
+func (starCityGames) Name() string  { return "scg" }
+func (starCityGames) Title() string { return "Star City Games" }
+
+func (starCityGames) MassEntry(d DeckList) string {
+	return nameCountLines(d)
+}
+
+func (starCityGames) URL(d DeckList) string {
+	return "https://starcitygames.com/shop/mass-entry/"
+}
+
+// cardmarket's wants list import takes the expansion in brackets:
+//
+//	4x Lightning Bolt (Magic 2010)
+type cardmarket struct{}
+
+// cardmarketSets maps MTGJSON set codes to Cardmarket expansion names where
+// they differ from the MTGJSON set name.
+var cardmarketSets = map[string]string{
+	"LEA": "Alpha",
+	"LEB": "Beta",
+	"2ED": "Unlimited",
+	"3ED": "Revised",
+	"4ED": "Fourth Edition",
+	"5ED": "Fifth Edition",
+	"6ED": "Sixth Edition",
+	"7ED": "Seventh Edition",
+	"8ED": "Eighth Edition",
+	"9ED": "Ninth Edition",
+	"10E": "Tenth Edition",
+	"TSB": "Time Spiral: Timeshifted",
+	"CMD": "Commander",
+	"CNS": "Conspiracy",
+	"MPS": "Kaladesh Inventions",
+	"EXP": "Zendikar Expeditions",
+	"STA": "Strixhaven Mystical Archive",
+	"PLC": "Planar Chaos",
+	"MED": "Masters Edition",
+	"ME2": "Masters Edition II",
+	"ME3": "Masters Edition III",
+	"ME4": "Masters Edition IV",
+}
+
+func (cardmarket) Name() string  { return "cardmarket" }
+func (cardmarket) Title() string { return "Cardmarket" }
+
+func (cardmarket) MassEntry(d DeckList) string {
+	return strings.Join(massEntryLines(d, func(e *CardEntry) string {
+		// Cards not listed with a set are the latest printing, often a
+		// promo, so any expansion is left to Cardmarket.
+		set := ""
+		if e.Printing {
+			set = cardmarketSets[strings.ToUpper(e.Card.SetCode)]
+			if set == "" {
+				set = e.Card.SetName
+			}
+		}
+		if set == "" {
+			return fmt.Sprintf("%dx %s", e.Count, e.Card.Name)
+		}
+		return fmt.Sprintf("%dx %s (%s)", e.Count, e.Card.Name, set)
+	}), "\n")
+}
+
+func (cardmarket) URL(d DeckList) string {
+	return "https://www.cardmarket.com/en/Magic/Wants"
+}
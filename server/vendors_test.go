@@ -0,0 +1,81 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hobeone/mtgbrew/db"
+	"github.com/hobeone/mtgbrew/mtgjson"
+)
+
+func TestVendorMassEntry(t *testing.T) {
+	d := DeckList{}
+	d.AddCard(&mtgjson.Card{Name: "Lightning Bolt", SetCode: "sta", SetName: "Strixhaven Mystical Archive"}, 4)
+	d.AddCard(&mtgjson.Card{Name: "Air Elemental", SetCode: "lea", SetName: "Limited Edition Alpha"}, 2)
+	d.AddCard(&mtgjson.Card{Name: "Goblin Guide", SetCode: "zen", SetName: "Zendikar"}, 1)
+	d.AddCard(&mtgjson.Card{Name: "Mountain"}, 3)
+	// Goblin Guide is just the latest printing
+	d["Lightning Bolt"].Printing = true
+	d["Air Elemental"].Printing = true
+
+	tests := map[string]string{
+		"tcgplayer":   "1 Goblin Guide||2 Air Elemental||3 Mountain||4 Lightning Bolt",
+		"cardkingdom": "2 Air Elemental\n1 Goblin Guide\n4 Lightning Bolt\n3 Mountain",
+		"scg":         "2 Air Elemental\n1 Goblin Guide\n4 Lightning Bolt\n3 Mountain",
+		"cardmarket":  "2x Air Elemental (Alpha)\n1x Goblin Guide\n4x Lightning Bolt (Strixhaven Mystical Archive)\n3x Mountain",
+	}
+	for name, expected := range tests {
+		v, err := VendorFor(name)
+		if err != nil {
+			t.Fatalf("Error getting vendor %s: %s", name, err)
+		}
+		if got := v.MassEntry(d); got != expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", name, expected, got)
+		}
+	}
+
+	_, err := VendorFor("ebay")
+	if err == nil {
+		t.Fatalf("Expected error for unknown vendor")
+	}
+}
+
+func TestTCGPlayerURL(t *testing.T) {
+	d := DeckList{}
+	d.AddCard(&mtgjson.Card{Name: "Krenko, Mob Boss"}, 1)
+	d.AddCard(&mtgjson.Card{Name: "Mountain"}, 4)
+
+	expected := "http://store.tcgplayer.com/list/selectproductmagic.aspx?c=1+Krenko%2C+Mob+Boss%7C%7C4+Mountain"
+	if got := (tcgPlayer{}).URL(d); got != expected {
+		t.Fatalf("Expected %s got %s", expected, got)
+	}
+}
+
+func TestCardmarketNamedPrintings(t *testing.T) {
+	dbh := db.MustNewMemoryDBHandle(false, logrus.StandardLogger(), false)
+	err := db.SaveCards(dbh, map[string]mtgjson.Set{
+		"STA": {Code: "STA", Name: "Strixhaven Mystical Archive", ReleaseDate: "2021-04-23", Cards: []*mtgjson.Card{
+			{MTGJsonID: "1", SetCode: "STA", SetName: "Strixhaven Mystical Archive", Name: "Lightning Bolt", Number: "42"},
+		}},
+		"SLD": {Code: "SLD", Name: "Secret Lair Drop", ReleaseDate: "2022-01-01", Cards: []*mtgjson.Card{
+			{MTGJsonID: "2", SetCode: "SLD", SetName: "Secret Lair Drop", Name: "Lightning Bolt", Number: "1"},
+			{MTGJsonID: "3", SetCode: "SLD", SetName: "Secret Lair Drop", Name: "Goblin Guide", Number: "2"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An unknown set falls back to the latest printing without naming it
+	list := "4 Lightning Bolt (STA) 42\n1 Goblin Guide (XXX) 9\n2 Goblin Guide\n"
+	deck, errs := readerToDeck(strings.NewReader(list), false, dbh)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	needed, _ := MultiBuyList([]NamedDeck{{Name: "Burn", Cards: deck}}, DefaultPlaysetLimit, false)
+	buy := subtractDeck(needed, DeckList{})
+	expected := "3x Goblin Guide\n4x Lightning Bolt (Strixhaven Mystical Archive)"
+	if got := (cardmarket{}).MassEntry(buy); got != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, got)
+	}
+}
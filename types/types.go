@@ -56,13 +56,34 @@ type Card struct {
 	SetURL   string `json:"set_url,omitempty"`
 }
 
+// Set is the API representation of a set of cards
 type Set struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Border   string `json:"border"`
-	Type     string `json:"type"`
-	Href     string `json:"url"`
-	CardsURL string `json:"cards_url"`
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Border       string `json:"border"`
+	Type         string `json:"type"`
+	Block        string `json:"block,omitempty"`
+	ReleaseDate  string `json:"release_date"`
+	GathererCode string `json:"gatherer_code,omitempty"`
+	OnlineOnly   bool   `json:"online_only,omitempty"`
+	Href         string `json:"url"`
+	CardsURL     string `json:"cards_url"`
+}
+
+// NewSet converts a mtgjson Set to its API representation
+func NewSet(s mtgjson.Set) Set {
+	return Set{
+		ID:           s.Code,
+		Name:         s.Name,
+		Border:       s.Border,
+		Type:         s.SetType,
+		Block:        s.Block,
+		ReleaseDate:  s.ReleaseDate,
+		GathererCode: s.GathererCode,
+		OnlineOnly:   s.OnlineOnly,
+		Href:         "/v1/sets/" + s.Code,
+		CardsURL:     "/v1/cards?set=" + s.Code,
+	}
 }
 
 func sha1String(name string) string {
@@ -99,17 +120,9 @@ func transformRarity(rarity string) string {
 func TransformLegalities(lgs []mtgjson.Legality) map[string]string {
 	formats := map[string]string{}
 	for _, l := range lgs {
-		switch l.Format {
-		case "Standard":
-			formats["standard"] = strings.ToLower(l.Legality)
-		case "Modern":
-			formats["modern"] = strings.ToLower(l.Legality)
-		case "Vintage":
-			formats["vintage"] = strings.ToLower(l.Legality)
-		case "Legacy":
-			formats["legacy"] = strings.ToLower(l.Legality)
-		case "Commander":
-			formats["commander"] = strings.ToLower(l.Legality)
+		switch f := strings.ToLower(l.Format); f {
+		case "standard", "modern", "vintage", "legacy", "commander":
+			formats[f] = strings.ToLower(l.Legality)
 		}
 	}
 	return formats